@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/castyapp/gateway.server/hub"
+)
+
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
+
+// Publish a system announcement to all gateway instances, e.g.
+// server --config-file config.hcl announce --message "maintenance in 10 minutes"
+func announce(args []string) error {
+
+	flags := flag.NewFlagSet("announce", flag.ContinueOnError)
+	message := flags.String("message", "", "Announcement message")
+	hubs := flags.String("hubs", "", "Comma separated hubs to announce to: user, theater")
+	users := flags.String("users", "", "Comma separated user ids to announce to")
+	theaters := flags.String("theaters", "", "Comma separated theater ids to announce to")
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	instances, err := hub.PublishSystemEvent(ctx, &hub.SystemEvent{
+		Type:       hub.SystemAnnouncement,
+		Message:    *message,
+		Hubs:       splitList(*hubs),
+		UserIds:    splitList(*users),
+		TheaterIds: splitList(*theaters),
+	})
+	if err != nil {
+		return fmt.Errorf("could not publish announcement: %v", err)
+	}
+
+	log.Printf("Announcement published to %d gateway instances", instances)
+	return nil
+}
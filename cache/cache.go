@@ -0,0 +1,202 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/castyapp/gateway.server/config"
+	"github.com/castyapp/gateway.server/redis"
+	"github.com/castyapp/libcasty-protocol-go/proto"
+	pb "github.com/golang/protobuf/proto"
+)
+
+var (
+	// Theaters caches GetTheater responses, tagged by theater id
+	Theaters *Cache
+	// Users caches token to user lookups, tagged by user id
+	Users *Cache
+
+	invalidations *redis.Subscription
+)
+
+// Cache keeps protobuf messages in an in-process LRU and optionally in redis
+// so other gateway instances can share the lookups. A nil *Cache is a valid
+// disabled cache.
+type Cache struct {
+	name   string
+	ttl    time.Duration
+	shared bool
+	lru    *LRU
+	tagOf  func(msg pb.Message) string
+}
+
+func (c *Cache) redisKey(key string) string {
+	return redis.Keys.Cache(c.name, key)
+}
+
+func (c *Cache) redisTagKey(tag string) string {
+	return redis.Keys.CacheTag(c.name, tag)
+}
+
+// Get a cached message by key and merge it into msg
+func (c *Cache) Get(ctx context.Context, key string, msg pb.Message) bool {
+	if c == nil {
+		return false
+	}
+	if value, ok := c.lru.Get(key); ok {
+		pb.Merge(msg, value.(pb.Message))
+		return true
+	}
+	if c.shared {
+		pipe := redis.Client.Pipeline()
+		get := pipe.Get(ctx, c.redisKey(key))
+		pttl := pipe.PTTL(ctx, c.redisKey(key))
+		if _, err := pipe.Exec(ctx); err != nil {
+			return false
+		}
+		data, err := get.Bytes()
+		if err != nil {
+			return false
+		}
+		if err := pb.Unmarshal(data, msg); err != nil {
+			return false
+		}
+		// keep it locally only for what is left of its ttl in redis, so an
+		// invalidated token does not outlive the shared ttl
+		if ttl := pttl.Val(); ttl > 0 {
+			c.lru.SetWithTTL(c.tagOf(msg), key, pb.Clone(msg), ttl)
+		}
+		return true
+	}
+	return false
+}
+
+// Set a message in cache
+func (c *Cache) Set(ctx context.Context, key string, msg pb.Message) {
+	if c == nil {
+		return
+	}
+	tag := c.tagOf(msg)
+	c.lru.Set(tag, key, pb.Clone(msg))
+	if c.shared {
+		data, err := pb.Marshal(msg)
+		if err != nil {
+			return
+		}
+		pipe := redis.Client.TxPipeline()
+		pipe.Set(ctx, c.redisKey(key), data, c.ttl)
+		pipe.SAdd(ctx, c.redisTagKey(tag), key)
+		pipe.Expire(ctx, c.redisTagKey(tag), c.ttl)
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Println(fmt.Errorf("could not store %s cache in redis REASON[%v]", c.name, err))
+		}
+	}
+}
+
+// Invalidate every entry of the given tag on all gateway instances
+func (c *Cache) Invalidate(ctx context.Context, tag string) error {
+	if c == nil {
+		return nil
+	}
+	c.invalidate(ctx, tag)
+	return redis.Client.Publish(ctx, redis.Keys.CacheInvalidation(), fmt.Sprintf("%s:%s", c.name, tag)).Err()
+}
+
+func (c *Cache) invalidate(ctx context.Context, tag string) {
+	c.lru.RemoveTag(tag)
+	if c.shared {
+		// keys of a tag are in different slots of redis cluster, they're
+		// deleted one at a time
+		tagKey := c.redisTagKey(tag)
+		pipe := redis.Client.Pipeline()
+		for _, key := range redis.Client.SMembers(ctx, tagKey).Val() {
+			pipe.Del(ctx, c.redisKey(key))
+		}
+		pipe.Del(ctx, tagKey)
+		if _, err := pipe.Exec(ctx); err != nil {
+			log.Println(fmt.Errorf("could not invalidate %s cache in redis REASON[%v]", c.name, err))
+		}
+	}
+}
+
+// HashToken returns a key for an authentication token that is safe to keep
+// in memory and redis
+func HashToken(token []byte) string {
+	if token == nil {
+		return "guest"
+	}
+	sum := sha256.Sum256(token)
+	return hex.EncodeToString(sum[:])
+}
+
+// TheaterKey returns the cache key of a theater requested with a token,
+// theaters are cached per token since the response depends on who asked
+func TheaterKey(theaterId string, token []byte) string {
+	return fmt.Sprintf("%s:%s", theaterId, HashToken(token))
+}
+
+func newCache(name string, tagOf func(msg pb.Message) string) *Cache {
+	ttl := time.Duration(config.Map.Cache.TTL) * time.Second
+	return &Cache{
+		name:   name,
+		ttl:    ttl,
+		shared: config.Map.Cache.Shared,
+		lru:    NewLRU(config.Map.Cache.Size, ttl),
+		tagOf:  tagOf,
+	}
+}
+
+// Invalidation events are published on the cache invalidation channel, payload
+// is "<cache name>:<tag>", e.g. "theaters:<theater id>" or "users:<user id>"
+func listenInvalidations() error {
+	subscription, err := redis.Subscribe(redis.Keys.CacheInvalidation(), func(payload string) {
+		parts := strings.SplitN(payload, ":", 2)
+		if len(parts) != 2 {
+			return
+		}
+		switch parts[0] {
+		case Theaters.name:
+			Theaters.invalidate(context.Background(), parts[1])
+		case Users.name:
+			Users.invalidate(context.Background(), parts[1])
+		}
+	})
+	if err != nil {
+		return err
+	}
+	invalidations = subscription
+	return nil
+}
+
+func Configure() error {
+
+	if !config.Map.Cache.Enabled {
+		return nil
+	}
+
+	if config.Map.Cache.TTL <= 0 {
+		return fmt.Errorf("cache ttl should be greater than zero")
+	}
+
+	Theaters = newCache("theaters", func(msg pb.Message) string {
+		return msg.(*proto.Theater).Id
+	})
+
+	Users = newCache("users", func(msg pb.Message) string {
+		return msg.(*proto.User).Id
+	})
+
+	return listenInvalidations()
+}
+
+func Close() error {
+	if invalidations != nil {
+		invalidations.Unsubscribe()
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	key       string
+	tag       string
+	value     interface{}
+	expiresAt time.Time
+}
+
+// LRU is a size bounded least recently used cache whose entries expire
+// after a fixed ttl. Every entry belongs to a tag so a group of entries
+// (e.g. every cached token of one user) can be invalidated at once.
+type LRU struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]*list.Element
+	tags  map[string]map[string]struct{}
+}
+
+// Get a value from cache, expired entries are removed on access
+func (c *LRU) Get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*entry)
+	if time.Now().After(e.expiresAt) {
+		c.removeElement(el)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return e.value, true
+}
+
+// Set a value in cache under the given tag
+func (c *LRU) Set(tag, key string, value interface{}) {
+	c.SetWithTTL(tag, key, value, c.ttl)
+}
+
+// Set a value that expires sooner than ttl of cache, e.g. a value that was
+// already cached somewhere else for a while
+func (c *LRU) SetWithTTL(tag, key string, value interface{}, ttl time.Duration) {
+	if ttl > c.ttl {
+		ttl = c.ttl
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+	el := c.ll.PushFront(&entry{
+		key:       key,
+		tag:       tag,
+		value:     value,
+		expiresAt: time.Now().Add(ttl),
+	})
+	c.items[key] = el
+	if _, ok := c.tags[tag]; !ok {
+		c.tags[tag] = make(map[string]struct{})
+	}
+	c.tags[tag][key] = struct{}{}
+	for c.size > 0 && c.ll.Len() > c.size {
+		c.removeElement(c.ll.Back())
+	}
+}
+
+// Remove a single key from cache
+func (c *LRU) Remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+}
+
+// RemoveTag removes every entry stored under the given tag
+func (c *LRU) RemoveTag(tag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.tags[tag] {
+		if el, ok := c.items[key]; ok {
+			c.removeElement(el)
+		}
+	}
+}
+
+// Len returns the number of entries in cache, including expired ones
+// that are not accessed yet
+func (c *LRU) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Purge removes all entries
+func (c *LRU) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[string]*list.Element)
+	c.tags = make(map[string]map[string]struct{})
+}
+
+func (c *LRU) removeElement(el *list.Element) {
+	e := el.Value.(*entry)
+	c.ll.Remove(el)
+	delete(c.items, e.key)
+	if keys, ok := c.tags[e.tag]; ok {
+		delete(keys, e.key)
+		if len(keys) == 0 {
+			delete(c.tags, e.tag)
+		}
+	}
+}
+
+// Create a new LRU cache, size <= 0 means unbounded
+func NewLRU(size int, ttl time.Duration) *LRU {
+	return &LRU{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+		tags:  make(map[string]map[string]struct{}),
+	}
+}
@@ -7,11 +7,16 @@ import (
 )
 
 type ConfMap struct {
-	Debug  bool         `hcl:"debug"`
-	Env    string       `hcl:"env"`
-	Grpc   GrpcConfig   `hcl:"grpc,block"`
-	Redis  RedisConfig  `hcl:"redis,block"`
-	Sentry SentryConfig `hcl:"sentry,block"`
+	Debug     bool            `hcl:"debug"`
+	Env       string          `hcl:"env"`
+	Grpc      GrpcConfig      `hcl:"grpc,block"`
+	Redis     RedisConfig     `hcl:"redis,block"`
+	Sentry    SentryConfig    `hcl:"sentry,block"`
+	Cache     CacheConfig     `hcl:"cache,block"`
+	Theater   TheaterConfig   `hcl:"theater,block"`
+	RateLimit RateLimitConfig `hcl:"rate_limit,block"`
+	Admin     AdminConfig     `hcl:"admin,block"`
+	Health    HealthConfig    `hcl:"health,block"`
 }
 
 type SentryConfig struct {
@@ -20,17 +25,77 @@ type SentryConfig struct {
 }
 
 type RedisConfig struct {
-	Cluster      bool     `hcl:"cluster"`
-	MasterName   string   `hcl:"master_name"`
-	Addr         string   `hcl:"addr"`
-	Sentinels    []string `hcl:"sentinels"`
-	Pass         string   `hcl:"pass"`
-	SentinelPass string   `hcl:"sentinel_pass"`
+	// standalone, sentinel or cluster
+	Mode string `hcl:"mode"`
+	// Deprecated: use mode = "sentinel"
+	Cluster    bool   `hcl:"cluster"`
+	MasterName string `hcl:"master_name"`
+	Addr       string `hcl:"addr"`
+	// seed nodes of redis cluster
+	Addrs                 []string `hcl:"addrs"`
+	Sentinels             []string `hcl:"sentinels"`
+	Username              string   `hcl:"username"`
+	Pass                  string   `hcl:"pass"`
+	SentinelPass          string   `hcl:"sentinel_pass"`
+	TLS                   bool     `hcl:"tls"`
+	TLSInsecureSkipVerify bool     `hcl:"tls_insecure_skip_verify"`
+	// prepended to every key and channel, e.g. "staging"
+	Prefix string `hcl:"prefix"`
+}
+
+type CacheConfig struct {
+	Enabled bool `hcl:"enabled"`
+	Size    int  `hcl:"size"`
+	TTL     int  `hcl:"ttl"`
+	Shared  bool `hcl:"shared"`
+}
+
+type TheaterConfig struct {
+	ReadyQuorum            float64 `hcl:"ready_quorum"`
+	TimeSyncInterval       int     `hcl:"time_sync_interval"`
+	DriftThreshold         float64 `hcl:"drift_threshold"`
+	DriftBroadcastInterval int     `hcl:"drift_broadcast_interval"`
+	VoteThreshold          float64 `hcl:"vote_threshold"`
+	VoteTimeout            int     `hcl:"vote_timeout"`
+	ReactionsPerSecond     int     `hcl:"reactions_per_second"`
+	ReactionsBatchMembers  int     `hcl:"reactions_batch_members"`
+	MaxClients             int     `hcl:"max_clients"`
+	GlobalMaxClients       int     `hcl:"global_max_clients"`
+	// maximum clients of theaters by theater id, overrides max_clients
+	MaxClientsOverrides map[string]int `hcl:"max_clients_overrides"`
+	// bcrypt hashes of passwords of password protected theaters
+	PasswordHashes map[string]string `hcl:"password_hashes"`
+}
+
+type RateLimitConfig struct {
+	Enabled bool                        `hcl:"enabled"`
+	Events  map[string]EventLimitConfig `hcl:"event"`
+}
+
+// Token bucket limits of an event, rate is tokens per second. Room limits
+// are shared by all members of a theater
+type EventLimitConfig struct {
+	Rate      float64 `hcl:"rate"`
+	Burst     int     `hcl:"burst"`
+	RoomRate  float64 `hcl:"room_rate"`
+	RoomBurst int     `hcl:"room_burst"`
+}
+
+type AdminConfig struct {
+	Token string `hcl:"token"`
+}
+
+type HealthConfig struct {
+	CheckInterval  int `hcl:"check_interval"`
+	StartupTimeout int `hcl:"startup_timeout"`
+	DrainTimeout   int `hcl:"drain_timeout"`
 }
 
 type GrpcConfig struct {
 	Host string `hcl:"host"`
 	Port int    `hcl:"port"`
+	// token of gateway to change users of crashed gateway instances
+	ServiceToken string `hcl:"service_token"`
 }
 
 var Map = new(ConfMap)
@@ -5,7 +5,9 @@ import (
 	"log"
 	"time"
 
+	"github.com/castyapp/gateway.server/metrics"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
 )
 
 func clientInterceptor(
@@ -22,7 +24,9 @@ func clientInterceptor(
 	// Calls the invoker to execute RPC
 	err := invoker(ctx, method, req, reply, cc, opts...)
 	// Logic after invoking the invoker
-	log.Printf("Invoked RPC method=%s; Duration=%s; Error=%v", method, time.Since(start), err)
+	duration := time.Since(start)
+	metrics.GrpcDuration.WithLabelValues(method, status.Code(err).String()).Observe(duration.Seconds())
+	log.Printf("Invoked RPC method=%s; Duration=%s; Error=%v", method, duration, err)
 	return err
 }
 
@@ -1,15 +1,19 @@
 package grpc
 
 import (
+	"context"
 	"fmt"
-	"log"
 
 	"github.com/castyapp/gateway.server/config"
 	"github.com/castyapp/libcasty-protocol-go/proto"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/metadata"
 )
 
 var (
+	conn *grpc.ClientConn
+
 	UserServiceClient     proto.UserServiceClient
 	TheaterServiceClient  proto.TheaterServiceClient
 	MessagesServiceClient proto.MessagesServiceClient
@@ -22,9 +26,11 @@ func Configure() error {
 		port = config.Map.Grpc.Port
 	)
 
-	conn, err := grpc.Dial(fmt.Sprintf("%s:%d", host, port), grpc.WithInsecure(), WithAuthInterceptor())
+	// dial is non-blocking, connection is checked by Ping
+	var err error
+	conn, err = grpc.Dial(fmt.Sprintf("%s:%d", host, port), grpc.WithInsecure(), WithAuthInterceptor())
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	UserServiceClient = proto.NewUserServiceClient(conn)
@@ -32,3 +38,27 @@ func Configure() error {
 	MessagesServiceClient = proto.NewMessagesServiceClient(conn)
 	return nil
 }
+
+// Make grpc calls of ctx on behalf of a user, services accept it along with
+// the service token of gateway
+func OnBehalfOf(ctx context.Context, userId string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "on-behalf-of", userId)
+}
+
+// Get connectivity state of grpc connection
+func State() connectivity.State {
+	return conn.GetState()
+}
+
+// Wait until grpc connection is ready or ctx is done
+func Ping(ctx context.Context) error {
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if !conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("grpc connection is %s", state)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/castyapp/gateway.server/config"
+	"github.com/castyapp/gateway.server/metrics"
+	"github.com/getsentry/sentry-go"
+)
+
+const (
+	defaultCheckInterval  = 5 * time.Second
+	defaultStartupTimeout = time.Minute
+	checkTimeout          = 3 * time.Second
+	maxBackoff            = 10 * time.Second
+)
+
+// Dependency is a backend the gateway can't serve new clients without
+type Dependency struct {
+	Name  string
+	check func(ctx context.Context) error
+	mu    sync.RWMutex
+	err   error
+}
+
+// Get error of the last check, nil if dependency is up
+func (d *Dependency) Err() error {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return d.err
+}
+
+func (d *Dependency) run(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	err := d.check(ctx)
+
+	d.mu.Lock()
+	previous := d.err
+	d.err = err
+	d.mu.Unlock()
+
+	if err != nil {
+		metrics.DependencyUp.WithLabelValues(d.Name).Set(0)
+		if previous == nil {
+			mErr := fmt.Errorf("%s is unavailable, gateway is degraded: %v", d.Name, err)
+			sentry.CaptureException(mErr)
+			log.Println(mErr)
+		}
+	} else {
+		metrics.DependencyUp.WithLabelValues(d.Name).Set(1)
+		if previous != nil {
+			log.Printf("%s is available again", d.Name)
+		}
+	}
+	return err
+}
+
+var (
+	mu           sync.RWMutex
+	dependencies = make(map[string]*Dependency)
+)
+
+// Register a dependency with a check that returns an error when it's down
+func Register(name string, check func(ctx context.Context) error) {
+	mu.Lock()
+	defer mu.Unlock()
+	dependencies[name] = &Dependency{Name: name, check: check}
+}
+
+// Get registered dependencies sorted by name
+func Dependencies() []*Dependency {
+	mu.RLock()
+	defer mu.RUnlock()
+	result := make([]*Dependency, 0, len(dependencies))
+	for _, d := range dependencies {
+		result = append(result, d)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// Check if any dependency is down, new logons are refused while degraded
+func Degraded() bool {
+	return len(Unavailable()) > 0
+}
+
+// Get names of dependencies that are down
+func Unavailable() []string {
+	var names []string
+	for _, d := range Dependencies() {
+		if d.Err() != nil {
+			names = append(names, d.Name)
+		}
+	}
+	return names
+}
+
+func checkInterval() time.Duration {
+	if interval := config.Map.Health.CheckInterval; interval > 0 {
+		return time.Duration(interval) * time.Second
+	}
+	return defaultCheckInterval
+}
+
+func startupTimeout() time.Duration {
+	if timeout := config.Map.Health.StartupTimeout; timeout > 0 {
+		return time.Duration(timeout) * time.Second
+	}
+	return defaultStartupTimeout
+}
+
+// Wait for all dependencies to be up, checks are retried with exponential
+// backoff until startup timeout
+func WaitForAll(ctx context.Context) error {
+
+	ctx, cancel := context.WithTimeout(ctx, startupTimeout())
+	defer cancel()
+
+	for _, d := range Dependencies() {
+		backoff := 500 * time.Millisecond
+		for {
+			err := d.run(ctx)
+			if err == nil {
+				break
+			}
+			log.Printf("Waiting for %s, retrying in %s: %v", d.Name, backoff, err)
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("%s is unavailable: %v", d.Name, err)
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+		}
+	}
+
+	return nil
+}
+
+// Check dependencies continuously until ctx is done
+func Monitor(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(checkInterval())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				for _, d := range Dependencies() {
+					_ = d.run(ctx)
+				}
+			}
+		}
+	}()
+}
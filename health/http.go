@@ -0,0 +1,67 @@
+package health
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Hub reports its state to health endpoints
+type Hub interface {
+	Name() string
+	Connections() int
+	Draining() bool
+}
+
+// Report of a gateway hub and its dependencies, readiness is only reported
+// by the readiness endpoint
+type Report struct {
+	Hub          string            `json:"hub"`
+	Ready        *bool             `json:"ready,omitempty"`
+	Draining     bool              `json:"draining"`
+	Connections  int               `json:"connections"`
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+}
+
+func report(hub Hub) *Report {
+	return &Report{
+		Hub:         hub.Name(),
+		Draining:    hub.Draining(),
+		Connections: hub.Connections(),
+	}
+}
+
+func writeReport(w http.ResponseWriter, status int, r *Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(r)
+}
+
+// Liveness of hub, it's alive as long as it can serve the report. Dependencies
+// are not checked so an outage of them doesn't restart gateways
+func LivenessHandler(hub Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		writeReport(w, http.StatusOK, report(hub))
+	}
+}
+
+// Readiness of hub, it's not ready while draining or a dependency is down.
+// State of dependencies is the result of their last check by Monitor
+func ReadinessHandler(hub Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		r := report(hub)
+		r.Dependencies = make(map[string]string)
+		for _, d := range Dependencies() {
+			r.Dependencies[d.Name] = "ok"
+			if err := d.Err(); err != nil {
+				r.Dependencies[d.Name] = err.Error()
+			}
+		}
+		ready := !r.Draining && !Degraded()
+		r.Ready = &ready
+		status := http.StatusOK
+		if !ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeReport(w, status, r)
+	}
+}
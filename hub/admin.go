@@ -0,0 +1,398 @@
+package hub
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/castyapp/gateway.server/config"
+	"github.com/castyapp/gateway.server/instance"
+	"github.com/castyapp/gateway.server/redis"
+	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// Replies of gateway instances are collected within this duration
+const adminReplyTimeout = 2 * time.Second
+
+// Actions of admin requests
+const (
+	adminListClients = "clients"
+	adminListRooms   = "rooms"
+	adminDisconnect  = "disconnect"
+	adminCloseRoom   = "close_room"
+	adminNotice      = "notice"
+)
+
+type adminRequest struct {
+	Id        string `json:"id"`
+	Action    string `json:"action"`
+	Hub       string `json:"hub,omitempty"`
+	ClientId  string `json:"client_id,omitempty"`
+	TheaterId string `json:"theater_id,omitempty"`
+	Message   string `json:"message,omitempty"`
+}
+
+// ClientInfo describes a connected client
+type ClientInfo struct {
+	Id             string    `json:"id"`
+	Hub            string    `json:"hub"`
+	UserId         string    `json:"user_id,omitempty"`
+	Guest          bool      `json:"guest"`
+	RemoteAddr     string    `json:"remote_addr"`
+	ConnectedSince time.Time `json:"connected_since"`
+	Room           string    `json:"room,omitempty"`
+	Waiting        bool      `json:"waiting,omitempty"`
+}
+
+// RoomInfo describes a theater room of a gateway instance
+type RoomInfo struct {
+	Id      string `json:"id"`
+	Clients int    `json:"clients"`
+	Waiting int    `json:"waiting"`
+}
+
+type adminReply struct {
+	Instance string       `json:"instance"`
+	Clients  []ClientInfo `json:"clients,omitempty"`
+	Rooms    []RoomInfo   `json:"rooms,omitempty"`
+	Affected int          `json:"affected"`
+}
+
+// Admin serves the admin api, requests are routed to all gateway instances
+// through redis so any instance can answer for the whole cluster
+type Admin struct {
+	users    *UserHub
+	theaters *TheaterHub
+}
+
+// Check bearer token of admin requests, admin api is disabled without a token
+func (admin *Admin) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := config.Map.Admin.Token
+		given := []byte(req.Header.Get("Authorization"))
+		if token == "" || subtle.ConstantTimeCompare(given, []byte("Bearer "+token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
+
+// Register admin api routes
+func (admin *Admin) Register(router *mux.Router) {
+	r := router.PathPrefix("/admin").Subrouter()
+	r.Use(admin.authenticate)
+	r.HandleFunc("/clients", admin.clientsHandler).Methods(http.MethodGet)
+	r.HandleFunc("/clients/{id}", admin.disconnectHandler).Methods(http.MethodDelete)
+	r.HandleFunc("/theaters", admin.roomsHandler).Methods(http.MethodGet)
+	r.HandleFunc("/theaters/{id}", admin.theaterHandler).Methods(http.MethodGet)
+	r.HandleFunc("/theaters/{id}", admin.closeRoomHandler).Methods(http.MethodDelete)
+	r.HandleFunc("/theaters/{id}/drift", admin.theaters.DriftStatsHandler).Methods(http.MethodGet)
+	r.HandleFunc("/notices", admin.noticeHandler).Methods(http.MethodPost)
+	r.HandleFunc("/instances", admin.instancesHandler).Methods(http.MethodGet)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (admin *Admin) clientsHandler(w http.ResponseWriter, req *http.Request) {
+	replies, err := admin.request(req.Context(), &adminRequest{
+		Action:    adminListClients,
+		Hub:       req.URL.Query().Get("hub"),
+		TheaterId: req.URL.Query().Get("theater_id"),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	clients := make([]ClientInfo, 0)
+	for _, reply := range replies {
+		clients = append(clients, reply.Clients...)
+	}
+	writeJSON(w, http.StatusOK, clients)
+}
+
+func (admin *Admin) roomsHandler(w http.ResponseWriter, req *http.Request) {
+	replies, err := admin.request(req.Context(), &adminRequest{Action: adminListRooms})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	// rooms of all instances are merged by theater id
+	rooms := make(map[string]*RoomInfo)
+	for _, reply := range replies {
+		for _, room := range reply.Rooms {
+			if merged, ok := rooms[room.Id]; ok {
+				merged.Clients += room.Clients
+				merged.Waiting += room.Waiting
+				continue
+			}
+			info := room
+			rooms[room.Id] = &info
+		}
+	}
+	result := make([]*RoomInfo, 0, len(rooms))
+	for _, room := range rooms {
+		result = append(result, room)
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// Playback state is kept in redis so it is read directly
+func (admin *Admin) theaterHandler(w http.ResponseWriter, req *http.Request) {
+	theaterId := mux.Vars(req)["id"]
+	state, err := NewVideoPlayer(theaterId).State()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	ctx := req.Context()
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"id":           theaterId,
+		"playing":      state.Playing,
+		"position":     state.CurrentTime(time.Now()),
+		"rate":         state.Rate,
+		"updated_at":   state.UpdatedAt,
+		"auto_paused":  state.AutoPaused,
+		"buffering":    redis.Client.SCard(ctx, redis.Keys.TheaterBuffering(theaterId)).Val(),
+		"members":      redis.Client.HLen(ctx, redis.Keys.TheaterMembers(theaterId)).Val(),
+		"clients":      redis.Client.SCard(ctx, redis.Keys.TheaterClients(theaterId)).Val(),
+		"waiting":      redis.Client.LLen(ctx, redis.Keys.TheaterWaiting(theaterId)).Val(),
+		"current_item": redis.Client.Get(ctx, redis.Keys.TheaterQueueCurrent(theaterId)).Val(),
+	})
+}
+
+// Gateway instances are read from the registry, not asked through redis
+func (admin *Admin) instancesHandler(w http.ResponseWriter, req *http.Request) {
+	members, err := instance.Members(req.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	writeJSON(w, http.StatusOK, members)
+}
+
+func (admin *Admin) disconnectHandler(w http.ResponseWriter, req *http.Request) {
+	admin.affect(w, req, &adminRequest{
+		Action:   adminDisconnect,
+		ClientId: mux.Vars(req)["id"],
+	})
+}
+
+func (admin *Admin) closeRoomHandler(w http.ResponseWriter, req *http.Request) {
+	admin.affect(w, req, &adminRequest{
+		Action:    adminCloseRoom,
+		TheaterId: mux.Vars(req)["id"],
+	})
+}
+
+// Body has message and optionally hub, user or theater to send the notice to
+func (admin *Admin) noticeHandler(w http.ResponseWriter, req *http.Request) {
+	request := new(adminRequest)
+	if err := json.NewDecoder(req.Body).Decode(request); err != nil || request.Message == "" {
+		http.Error(w, "message is required", http.StatusBadRequest)
+		return
+	}
+	request.Action = adminNotice
+	admin.affect(w, req, request)
+}
+
+// Run a request that changes clients and respond with number of affected clients
+func (admin *Admin) affect(w http.ResponseWriter, req *http.Request, request *adminRequest) {
+	replies, err := admin.request(req.Context(), request)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	affected := 0
+	for _, reply := range replies {
+		affected += reply.Affected
+	}
+	if affected == 0 && request.Action != adminNotice {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]int{"affected": affected})
+}
+
+// Publish a request to gateway instances and collect their replies, a
+// request about a client only goes to the instance that owns it
+func (admin *Admin) request(ctx context.Context, request *adminRequest) ([]*adminReply, error) {
+
+	request.Id = uuid.New().String()
+	payload, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	// requests are answered by every instance on a per request list, a request
+	// about a client is published to the instance that owns it
+	channel := redis.Keys.AdminRequests()
+	instances := int64(1)
+	if request.ClientId != "" {
+		owner := instance.Owner(request.ClientId)
+		if alive, err := instance.IsMember(ctx, owner); err != nil || !alive {
+			return nil, err
+		}
+		channel = redis.Keys.AdminInstanceRequests(owner)
+	} else {
+		// replies are expected from registered instances, number of subscribers
+		// only counts subscribers of one node in redis cluster
+		members, err := instance.Members(ctx)
+		if err != nil {
+			return nil, err
+		}
+		instances = int64(len(members))
+	}
+
+	if err := redis.Client.Publish(ctx, channel, payload).Err(); err != nil {
+		return nil, err
+	}
+
+	key := redis.Keys.AdminReplies(request.Id)
+	defer redis.Client.Del(context.Background(), key)
+
+	deadline := time.Now().Add(adminReplyTimeout)
+	replies := make([]*adminReply, 0, instances)
+	for int64(len(replies)) < instances {
+		timeout := time.Until(deadline)
+		if timeout <= 0 {
+			break
+		}
+		result, err := redis.Client.BLPop(ctx, timeout, key).Result()
+		if err != nil {
+			break
+		}
+		reply := new(adminReply)
+		if err := json.Unmarshal([]byte(result[1]), reply); err != nil {
+			return nil, err
+		}
+		replies = append(replies, reply)
+	}
+
+	if len(replies) == 0 && instances > 0 {
+		return nil, errors.New("no gateway instance replied")
+	}
+	return replies, nil
+}
+
+// Listen on admin requests of the cluster and of this instance and reply
+// with state of this instance
+func (admin *Admin) listen(ctx context.Context) {
+	handler := func(payload string) {
+		request := new(adminRequest)
+		if err := json.Unmarshal([]byte(payload), request); err != nil {
+			log.Println(fmt.Errorf("could not read admin request REASON[%v]", err))
+			return
+		}
+		if err := admin.reply(ctx, request); err != nil {
+			sentry.CaptureException(fmt.Errorf("could not reply admin request: %v", err))
+		}
+	}
+	for _, channel := range []string{redis.Keys.AdminRequests(), redis.Keys.AdminInstanceRequests(instance.ID)} {
+		if err := redis.SubscribeContext(ctx, channel, handler); err != nil {
+			sentry.CaptureException(fmt.Errorf("could not subscribe to admin requests: %v", err))
+		}
+	}
+}
+
+func (admin *Admin) reply(ctx context.Context, request *adminRequest) error {
+
+	reply := &adminReply{Instance: instance.ID}
+
+	switch request.Action {
+	case adminListClients:
+		admin.eachClient(request.Hub, func(client *Client) {
+			info := client.info()
+			if request.TheaterId == "" || (info.Hub == TheaterRoomType.String() && info.Room == request.TheaterId) {
+				reply.Clients = append(reply.Clients, info)
+			}
+		})
+	case adminListRooms:
+		admin.theaters.rooms.IterCb(func(key string, v interface{}) {
+			room := v.(*TheaterRoom)
+			reply.Rooms = append(reply.Rooms, RoomInfo{
+				Id:      key,
+				Clients: room.clients.Count(),
+				Waiting: room.waiting.Count(),
+			})
+		})
+	case adminDisconnect:
+		admin.eachClient("", func(client *Client) {
+			if client.Id == request.ClientId {
+				client.disconnect("disconnected_by_admin")
+				reply.Affected++
+			}
+		})
+	case adminCloseRoom:
+		admin.eachClient(TheaterRoomType.String(), func(client *Client) {
+			if client.room != nil && client.room.GetName() == request.TheaterId {
+				client.disconnect("room_closed_by_admin")
+				reply.Affected++
+			}
+		})
+	case adminNotice:
+		notice, err := newNotice(request.Message)
+		if err != nil {
+			return err
+		}
+		admin.eachClient(request.Hub, func(client *Client) {
+			if request.TheaterId != "" && (client.room == nil || client.room.GetType() != TheaterRoomType || client.room.GetName() != request.TheaterId) {
+				return
+			}
+			if err := client.send(EMSG_SYSTEM_NOTICE, notice); err == nil {
+				reply.Affected++
+			}
+		})
+	default:
+		return fmt.Errorf("invalid admin action: %s", request.Action)
+	}
+
+	payload, err := json.Marshal(reply)
+	if err != nil {
+		return err
+	}
+	key := redis.Keys.AdminReplies(request.Id)
+	pipe := redis.Client.TxPipeline()
+	pipe.RPush(ctx, key, payload)
+	pipe.Expire(ctx, key, time.Minute)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// Iterate connected clients of this instance, hub filters clients by hub name.
+// Clients are copied out of the hub first, cb may write to them
+func (admin *Admin) eachClient(hub string, cb func(client *Client)) {
+	hub = strings.ToLower(hub)
+	if hub == "" || hub == UserRoomType.String() {
+		for _, v := range admin.users.clients.Items() {
+			cb(v.(*Client))
+		}
+	}
+	if hub == "" || hub == TheaterRoomType.String() {
+		for _, v := range admin.theaters.clients.Items() {
+			cb(v.(*Client))
+		}
+	}
+}
+
+// Create admin api of hubs and start answering admin requests of the cluster
+func NewAdmin(ctx context.Context, users *UserHub, theaters *TheaterHub) *Admin {
+	admin := &Admin{
+		users:    users,
+		theaters: theaters,
+	}
+	admin.listen(ctx)
+	return admin
+}
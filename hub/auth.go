@@ -10,8 +10,10 @@ type Auth struct {
 	authenticated bool
 	guest         bool
 	token         []byte
-	event         pb.Message
-	user          *proto.User
+	// token is the service token, grpc calls are made on behalf of user
+	service bool
+	event   pb.Message
+	user    *proto.User
 }
 
 func (a *Auth) User() *proto.User {
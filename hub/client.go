@@ -5,20 +5,30 @@ import (
 	"fmt"
 	"log"
 	"net"
-	"strconv"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/castyapp/libcasty-protocol-go/protocol"
 
+	"github.com/castyapp/gateway.server/cache"
 	"github.com/castyapp/gateway.server/grpc"
+	"github.com/castyapp/gateway.server/health"
+	"github.com/castyapp/gateway.server/instance"
+	"github.com/castyapp/gateway.server/metrics"
 	"github.com/castyapp/libcasty-protocol-go/proto"
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
 	pb "github.com/golang/protobuf/proto"
-	"github.com/google/uuid"
+	cmap "github.com/orcaman/concurrent-map"
 	"github.com/pkg/errors"
+	"google.golang.org/protobuf/types/known/structpb"
 )
 
+// Time a message has to be written to a client, a client that can't keep up
+// is disconnected instead of blocking its senders
+const writeTimeout = 10 * time.Second
+
 type Client struct {
 	Id            string
 	conn          net.Conn
@@ -33,11 +43,18 @@ type Client struct {
 	room          Room
 	roomType      RoomType
 	pingChan      chan struct{}
-}
-
-type ClientWithRoom struct {
-	Id   string
-	Room string
+	// serializes writes, messages are written to conn from several goroutines
+	writeMu sync.Mutex
+	// estimated round trip time in nanoseconds, accessed atomically
+	rtt int64
+	// set once the connection is closed, accessed atomically
+	closed int32
+	// first reason of disconnecting client
+	disconnectReason atomic.Value
+	remoteAddr       string
+	connectedAt      time.Time
+	// theater credentials sent before logon
+	credentials Credentials
 }
 
 // Get client authenticated user
@@ -55,6 +72,18 @@ func (c *Client) Token() []byte {
 	return c.auth.Token()
 }
 
+// Get context of grpc calls that change client's user, returns false when
+// client can't make them
+func (c *Client) userContext(ctx context.Context) (context.Context, bool) {
+	if c.IsGuest() || c.Token() == nil {
+		return ctx, false
+	}
+	if c.auth.service {
+		return grpc.OnBehalfOf(ctx, c.GetUser().Id), true
+	}
+	return ctx, true
+}
+
 // Set a callback when client authorized
 func (c *Client) OnAuthorized(callback func(auth Auth) Room) {
 	c.onAuthSuccess = callback
@@ -80,12 +109,65 @@ func (c *Client) OnLeave(cb func(room Room)) {
 	c.onLeaveRoom = cb
 }
 
-// Close client connection
-func (c *Client) Close() error {
-	if c.room != nil {
-		c.onLeaveRoom(c.room)
+// Set why client is disconnecting, only the first reason is kept
+func (c *Client) setDisconnectReason(reason string) {
+	if c.disconnectReason.Load() == nil {
+		c.disconnectReason.Store(reason)
 	}
+}
+
+// Disconnect client for the given reason, client leaves its room when its
+// connection is closed
+func (c *Client) disconnect(reason string) {
+	c.setDisconnectReason(reason)
+	c.ctxCancel()
 	_ = c.conn.Close()
+}
+
+// Disconnect connected clients of a hub on shutdown, each client leaves its
+// room and is disowned like on any other disconnect
+func closeClients(clients cmap.ConcurrentMap) {
+	for _, v := range clients.Items() {
+		client := v.(*Client)
+		client.disconnect("shutdown")
+		_ = client.Close()
+	}
+}
+
+// Get description of client
+func (c *Client) info() ClientInfo {
+	info := ClientInfo{
+		Id:             c.Id,
+		Hub:            c.roomType.String(),
+		Guest:          c.IsGuest(),
+		RemoteAddr:     c.remoteAddr,
+		ConnectedSince: c.connectedAt,
+	}
+	if c.IsAuthenticated() {
+		info.UserId = c.GetUser().Id
+	}
+	if c.room != nil {
+		info.Room = c.room.GetName()
+		if room, ok := c.room.(*TheaterRoom); ok {
+			info.Waiting = room.isWaiting(c)
+		}
+	}
+	return info
+}
+
+// Close client connection, client leaves its room only once however many
+// times it is closed
+func (c *Client) Close() error {
+	if atomic.CompareAndSwapInt32(&c.closed, 0, 1) {
+		if c.room != nil {
+			c.onLeaveRoom(c.room)
+			instance.Disown(context.Background(), c.Id)
+		}
+		_ = c.conn.Close()
+		c.setDisconnectReason("closed")
+		metrics.ConnectedClients.WithLabelValues(c.roomType.String()).Dec()
+		metrics.Disconnects.WithLabelValues(c.roomType.String(), c.disconnectReason.Load().(string)).Inc()
+	}
 	return errors.New(fmt.Sprintf("Client [%s] disconnected!", c.Id))
 }
 
@@ -124,6 +206,7 @@ func (c *Client) Listen() {
 
 			data, err := wsutil.ReadClientBinary(c.conn)
 			if err != nil {
+				c.setDisconnectReason("connection_closed")
 				return
 			}
 
@@ -139,16 +222,42 @@ func (c *Client) Listen() {
 					continue
 				}
 
+				metrics.EventsReceived.WithLabelValues(c.roomType.String(), emsgLabel(packet.EMsg)).Inc()
+
 				switch packet.EMsg {
 				case proto.EMSG_PING:
 					c.pingChan <- struct{}{}
+				case EMSG_THEATER_CREDENTIALS:
+					// credentials are only used to join theater, they're never handled by rooms
+					if c.roomType == TheaterRoomType && c.room == nil {
+						event := new(structpb.Struct)
+						if err := packet.ReadProtoMsg(event); err == nil {
+							c.credentials = readCredentials(event)
+						}
+					}
+					continue
 				case proto.EMSG_LOGON:
+					if health.Degraded() {
+						c.refuseLogon()
+						metrics.LogOns.WithLabelValues(c.roomType.String(), "degraded").Inc()
+						c.setDisconnectReason("degraded")
+						c.ctxCancel()
+						_ = c.Close()
+						return
+					}
 					if err := c.authenticate(packet); err != nil {
 						log.Println(err)
+						metrics.LogOns.WithLabelValues(c.roomType.String(), "failure").Inc()
+						c.setDisconnectReason("logon_failed")
 						c.ctxCancel()
 						_ = c.Close()
 						return
 					}
+					if c.IsGuest() {
+						metrics.LogOns.WithLabelValues(c.roomType.String(), "guest").Inc()
+					} else {
+						metrics.LogOns.WithLabelValues(c.roomType.String(), "success").Inc()
+					}
 				}
 
 				c.Event <- packet
@@ -158,6 +267,21 @@ func (c *Client) Listen() {
 
 }
 
+// Tell client that gateway can't accept logons until its dependencies are back
+func (c *Client) refuseLogon() {
+	unavailable := make([]interface{}, 0)
+	for _, name := range health.Unavailable() {
+		unavailable = append(unavailable, name)
+	}
+	event, err := structpb.NewStruct(map[string]interface{}{
+		"reason":      "degraded",
+		"unavailable": unavailable,
+	})
+	if err == nil {
+		_ = c.send(EMSG_SERVICE_UNAVAILABLE, event)
+	}
+}
+
 // Get authentication token from LogOn event for User and Theater rooms
 func getTokenFromLogOnEvent(event pb.Message) []byte {
 	switch event.(type) {
@@ -169,8 +293,12 @@ func getTokenFromLogOnEvent(event pb.Message) []byte {
 	return nil
 }
 
-func (c *Client) send(eMsg proto.EMSG, body pb.Message) (err error) {
-	return protocol.BrodcastMsgProtobuf(c.conn, eMsg, body)
+func (c *Client) send(eMsg proto.EMSG, body pb.Message) error {
+	buffer, err := protocol.NewMsgProtobuf(eMsg, body)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(buffer.Bytes())
 }
 
 // Authenticate client with LogOn event
@@ -213,17 +341,13 @@ func (c *Client) authenticate(packet *protocol.Packet) error {
 
 	if !c.IsAuthenticated() {
 
-		mCtx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-		response, err := grpc.UserServiceClient.GetUser(mCtx, &proto.AuthenticateRequest{
-			Token: token,
-		})
-
+		user, err := GetUser(token)
 		if err != nil {
 			c.auth = Auth{err: err}
 			return err
 		} else {
 			c.auth = Auth{
-				user:          response.Result,
+				user:          user,
 				authenticated: true,
 				event:         event,
 				token:         token,
@@ -241,9 +365,41 @@ func (c *Client) authenticate(packet *protocol.Packet) error {
 	return nil
 }
 
+// Get authenticated user by token from grpc service
+func GetUser(token []byte) (*proto.User, error) {
+	cacheKey := cache.HashToken(token)
+	user := new(proto.User)
+	if cache.Users.Get(context.Background(), cacheKey, user) {
+		return user, nil
+	}
+	mCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	response, err := grpc.UserServiceClient.GetUser(mCtx, &proto.AuthenticateRequest{
+		Token: token,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if response.Result == nil {
+		return nil, errors.New("could not find user")
+	}
+	cache.Users.Set(mCtx, cacheKey, response.Result)
+	return response.Result, nil
+}
+
 // Write message to client
 func (c *Client) WriteMessage(msg []byte) (err error) {
+	pending := metrics.PendingWrites.WithLabelValues(c.roomType.String())
+	pending.Inc()
+	defer pending.Dec()
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	_ = c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
 	err = wsutil.WriteServerMessage(c.conn, ws.OpBinary, msg)
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		// a partly written frame can't be continued
+		c.disconnect("write_timeout")
+	}
 	return
 }
 
@@ -260,17 +416,19 @@ func NewUserClient(ctx context.Context, conn net.Conn) (client *Client) {
 // Create a new client
 func NewClient(ctx context.Context, conn net.Conn, rType RoomType) *Client {
 	mCtx, cancelFunc := context.WithCancel(ctx)
-	clientID := strconv.Itoa(int(uuid.New().ID()))
 	client := &Client{
-		Id:        clientID,
-		conn:      conn,
-		ctx:       mCtx,
-		ctxCancel: cancelFunc,
-		Event:     make(chan *protocol.Packet),
-		auth:      Auth{},
-		roomType:  rType,
-		pingChan:  make(chan struct{}),
+		Id:          instance.NextClientId(),
+		conn:        conn,
+		ctx:         mCtx,
+		ctxCancel:   cancelFunc,
+		Event:       make(chan *protocol.Packet),
+		auth:        Auth{},
+		roomType:    rType,
+		pingChan:    make(chan struct{}),
+		remoteAddr:  conn.RemoteAddr().String(),
+		connectedAt: time.Now(),
 	}
+	metrics.ConnectedClients.WithLabelValues(rType.String()).Inc()
 	client.onLeaveRoom = func(room Room) {
 		room.Leave(client)
 	}
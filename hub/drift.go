@@ -0,0 +1,137 @@
+package hub
+
+import (
+	"encoding/json"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/castyapp/gateway.server/config"
+	"github.com/castyapp/libcasty-protocol-go/proto"
+	"github.com/getsentry/sentry-go"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultDriftThreshold         = 2.0
+	defaultDriftBroadcastInterval = 5 * time.Second
+)
+
+// DriftStats holds drift statistics of clients of a theater room that are
+// connected to this gateway instance, drift values are in seconds
+type DriftStats struct {
+	mu        sync.Mutex
+	Reports   int64              `json:"reports"`
+	Resyncs   int64              `json:"resyncs"`
+	MaxDrift  float64            `json:"max_drift"`
+	MeanDrift float64            `json:"mean_drift"`
+	Clients   map[string]float64 `json:"clients"`
+}
+
+func (s *DriftStats) add(clientId string, drift float64, resynced bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	abs := math.Abs(drift)
+	s.Reports++
+	s.MeanDrift += (abs - s.MeanDrift) / float64(s.Reports)
+	if abs > s.MaxDrift {
+		s.MaxDrift = abs
+	}
+	if resynced {
+		s.Resyncs++
+	}
+	s.Clients[clientId] = drift
+}
+
+func (s *DriftStats) remove(clientId string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.Clients, clientId)
+}
+
+func (s *DriftStats) MarshalJSON() ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	type stats DriftStats
+	return json.Marshal((*stats)(s))
+}
+
+func newDriftStats() *DriftStats {
+	return &DriftStats{Clients: make(map[string]float64)}
+}
+
+// Get drift in seconds of a position reported by a client with the given
+// round trip time, the client reported it about half a round trip before now
+func Drift(state *PlayerState, reported float64, rtt time.Duration, now time.Time) float64 {
+	return reported - state.CurrentTime(now.Add(-rtt/2))
+}
+
+func driftThreshold() float64 {
+	if threshold := config.Map.Theater.DriftThreshold; threshold > 0 {
+		return threshold
+	}
+	return defaultDriftThreshold
+}
+
+// Compare a client's reported position with the theater clock and resync
+// the client if it drifted more than the configured threshold
+func (room *TheaterRoom) ReportPosition(client *Client, report *proto.TheaterVideoPlayer) error {
+
+	state, err := room.vp.State()
+	if err != nil {
+		return err
+	}
+
+	drift := Drift(state, float64(report.CurrentTime), client.RTT(), time.Now())
+	resync := math.Abs(drift) > driftThreshold()
+	room.drift.add(client.Id, drift, resync)
+
+	if resync {
+		log.Printf("[%s] Client drifted %.3fs from theater [%s], resyncing...", client.Id, drift, room.GetName())
+		room.Sync(client)
+	}
+
+	return nil
+}
+
+// Broadcast the authoritative position to local clients while theater is
+// playing so they can detect and report drift
+func (room *TheaterRoom) broadcastPosition() {
+
+	interval := time.Duration(config.Map.Theater.DriftBroadcastInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultDriftBroadcastInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-room.ctx.Done():
+			return
+		case <-ticker.C:
+			state, err := room.vp.State()
+			if err != nil {
+				sentry.CaptureException(err)
+				continue
+			}
+			if state.Playing {
+				room.sendToLocalClients(proto.EMSG_PLAYING, room.newTheaterVideoPlayer(state))
+			}
+		}
+	}
+}
+
+// Get drift statistics of a theater room on this instance as json
+func (hub *TheaterHub) DriftStatsHandler(w http.ResponseWriter, req *http.Request) {
+	room, err := hub.FindRoom(mux.Vars(req)["id"])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(room.(*TheaterRoom).drift)
+}
@@ -0,0 +1,122 @@
+package hub
+
+import "github.com/castyapp/libcasty-protocol-go/proto"
+
+// Gateway events that are not part of libcasty-protocol-go yet. Values start
+// far from the protocol's own EMSGs so they never collide with upstream ones.
+// Values are part of the protocol of clients, they never change and values of
+// removed events are never used again.
+const (
+	// TheaterVideoPlayer with the new current_time, state is preserved
+	EMSG_THEATER_SEEK proto.EMSG = 1000
+	// wrapperspb.FloatValue with the new playback rate
+	EMSG_THEATER_PLAYBACK_RATE proto.EMSG = 1001
+	// structpb.Struct with NTP style timestamps, see Client.handleTimeSync
+	EMSG_TIME_SYNC proto.EMSG = 1002
+	// TheaterVideoPlayer with the position a client is playing at
+	EMSG_THEATER_POSITION_REPORT proto.EMSG = 1003
+	// TheaterMediaSourcesResponse with the queue items in play order
+	EMSG_THEATER_QUEUE proto.EMSG = 1004
+	// MediaSource to add to the end of queue
+	EMSG_THEATER_QUEUE_ADD proto.EMSG = 1005
+	// MediaSource to remove from queue, host and co-host only
+	EMSG_THEATER_QUEUE_REMOVE proto.EMSG = 1006
+	// structpb.Struct with media_source_id and the new index in queue, host
+	// and co-host only
+	EMSG_THEATER_QUEUE_MOVE proto.EMSG = 1007
+	// No body, plays the next media source of queue
+	EMSG_THEATER_QUEUE_SKIP proto.EMSG = 1008
+	// wrapperspb.BoolValue, theater owner toggles democratic mode with it
+	EMSG_THEATER_DEMOCRATIC_MODE proto.EMSG = 1009
+	// structpb.Struct with action, members send it to vote and the gateway
+	// broadcasts it with user_id, votes, required and timeout
+	EMSG_THEATER_VOTE proto.EMSG = 1010
+	// structpb.Struct with action and passed
+	EMSG_THEATER_VOTE_RESULT proto.EMSG = 1011
+	// structpb.Struct with emoji and media_time, broadcasted with user_id
+	EMSG_THEATER_REACTION proto.EMSG = 1012
+	// structpb.Struct with reactions, a list of emoji, count and media_time
+	// aggregated per batch interval in large rooms
+	EMSG_THEATER_REACTIONS proto.EMSG = 1013
+	// timestamppb.Timestamp of the scheduled start, zero cancels the schedule
+	EMSG_THEATER_SCHEDULE proto.EMSG = 1014
+	// structpb.Struct with starts_at and remaining seconds
+	EMSG_THEATER_COUNTDOWN proto.EMSG = 1015
+	// InviteFriendsTheaterRequest with friend_ids to invite to theater, invitees
+	// receive an EMSG_NEW_NOTIFICATION of type NEW_THEATER_INVITE and inviter
+	// receives it back with friend_ids that were invited
+	EMSG_THEATER_INVITE proto.EMSG = 1016
+	// structpb.Struct with invite_id and accepted sent on the user gateway,
+	// inviter receives it on the theater gateway with theater_id and user_id
+	EMSG_THEATER_INVITE_ANSWER proto.EMSG = 1017
+	// wrapperspb.StringValue with user id of the member in control of theater
+	EMSG_THEATER_HOST_CHANGED proto.EMSG = 1018
+	// wrapperspb.StringValue with user id of the co-host, empty removes it
+	EMSG_THEATER_COHOST proto.EMSG = 1019
+	// structpb.Struct with mode and password or invite_code of theater access
+	EMSG_THEATER_ACCESS proto.EMSG = 1020
+	// structpb.Struct with theater_id and reason a client could not join theater
+	EMSG_THEATER_ACCESS_DENIED proto.EMSG = 1021
+	// structpb.Struct with theater_id, position and size of waiting list
+	EMSG_THEATER_WAITING proto.EMSG = 1022
+	// wrapperspb.StringValue with id of the client admitted from waiting list,
+	// published between gateway instances only
+	EMSG_THEATER_ADMIT proto.EMSG = 1023
+	// structpb.Struct with event name and retry_after seconds of a rate limited event
+	EMSG_THROTTLED proto.EMSG = 1024
+	// structpb.Struct with message of a notice sent by gateway operators, from
+	// the admin api or as an announcement to all gateway instances
+	EMSG_SYSTEM_NOTICE proto.EMSG = 1025
+	// 1026 was EMSG_SYSTEM_ANNOUNCEMENT, announcements are system notices now
+
+	// structpb.Struct with reason and unavailable dependencies of a refused
+	// logon, or theater_id of a theater that could not seat the client
+	EMSG_SERVICE_UNAVAILABLE proto.EMSG = 1027
+	// structpb.Struct with password or invite_code of a theater, sent before
+	// the logon event to join a protected theater
+	EMSG_THEATER_CREDENTIALS proto.EMSG = 1028
+)
+
+// Names of gateway EMSGs
+var emsgNames = map[proto.EMSG]string{
+	EMSG_THEATER_SEEK:            "EMSG_THEATER_SEEK",
+	EMSG_THEATER_PLAYBACK_RATE:   "EMSG_THEATER_PLAYBACK_RATE",
+	EMSG_TIME_SYNC:               "EMSG_TIME_SYNC",
+	EMSG_THEATER_POSITION_REPORT: "EMSG_THEATER_POSITION_REPORT",
+	EMSG_THEATER_QUEUE:           "EMSG_THEATER_QUEUE",
+	EMSG_THEATER_QUEUE_ADD:       "EMSG_THEATER_QUEUE_ADD",
+	EMSG_THEATER_QUEUE_REMOVE:    "EMSG_THEATER_QUEUE_REMOVE",
+	EMSG_THEATER_QUEUE_MOVE:      "EMSG_THEATER_QUEUE_MOVE",
+	EMSG_THEATER_QUEUE_SKIP:      "EMSG_THEATER_QUEUE_SKIP",
+	EMSG_THEATER_DEMOCRATIC_MODE: "EMSG_THEATER_DEMOCRATIC_MODE",
+	EMSG_THEATER_VOTE:            "EMSG_THEATER_VOTE",
+	EMSG_THEATER_VOTE_RESULT:     "EMSG_THEATER_VOTE_RESULT",
+	EMSG_THEATER_REACTION:        "EMSG_THEATER_REACTION",
+	EMSG_THEATER_REACTIONS:       "EMSG_THEATER_REACTIONS",
+	EMSG_THEATER_SCHEDULE:        "EMSG_THEATER_SCHEDULE",
+	EMSG_THEATER_COUNTDOWN:       "EMSG_THEATER_COUNTDOWN",
+	EMSG_THEATER_INVITE:          "EMSG_THEATER_INVITE",
+	EMSG_THEATER_INVITE_ANSWER:   "EMSG_THEATER_INVITE_ANSWER",
+	EMSG_THEATER_HOST_CHANGED:    "EMSG_THEATER_HOST_CHANGED",
+	EMSG_THEATER_COHOST:          "EMSG_THEATER_COHOST",
+	EMSG_THEATER_ACCESS:          "EMSG_THEATER_ACCESS",
+	EMSG_THEATER_ACCESS_DENIED:   "EMSG_THEATER_ACCESS_DENIED",
+	EMSG_THEATER_WAITING:         "EMSG_THEATER_WAITING",
+	EMSG_THEATER_ADMIT:           "EMSG_THEATER_ADMIT",
+	EMSG_THROTTLED:               "EMSG_THROTTLED",
+	EMSG_SYSTEM_NOTICE:           "EMSG_SYSTEM_NOTICE",
+	EMSG_SERVICE_UNAVAILABLE:     "EMSG_SERVICE_UNAVAILABLE",
+	EMSG_THEATER_CREDENTIALS:     "EMSG_THEATER_CREDENTIALS",
+}
+
+// Get name of an EMSG to use as a metrics label, EMSGs sent by clients are
+// not trusted so unknown ones share one label
+func emsgLabel(eMsg proto.EMSG) string {
+	if name, ok := emsgNames[eMsg]; ok {
+		return name
+	}
+	if _, ok := proto.EMSG_name[int32(eMsg)]; ok {
+		return eMsg.String()
+	}
+	return "unknown"
+}
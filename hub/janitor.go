@@ -0,0 +1,230 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/castyapp/gateway.server/config"
+	"github.com/castyapp/gateway.server/instance"
+	"github.com/castyapp/gateway.server/redis"
+	"github.com/castyapp/libcasty-protocol-go/proto"
+	"github.com/getsentry/sentry-go"
+	cmap "github.com/orcaman/concurrent-map"
+)
+
+// What is needed to clean up after a client when its instance died. Tokens
+// of users are not kept, changes of users are made with the service token
+type ownedClient struct {
+	Hub     string `json:"hub"`
+	Room    string `json:"room"`
+	UserId  string `json:"user_id,omitempty"`
+	Guest   bool   `json:"guest,omitempty"`
+	Waiting bool   `json:"waiting,omitempty"`
+}
+
+// Record client as owned by this instance, called again when its state changes
+func own(ctx context.Context, client *Client, waiting bool) {
+	record := ownedClient{
+		Hub:     client.roomType.String(),
+		Room:    client.room.GetName(),
+		Guest:   client.IsGuest(),
+		Waiting: waiting,
+	}
+	if client.IsAuthenticated() {
+		record.UserId = client.GetUser().Id
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	instance.Own(ctx, client.Id, data)
+}
+
+// Create a client without connection that stands in for a client of a dead
+// instance, so it can leave its room like any other client. Grpc calls of
+// the client are made on behalf of its user with the service token, they're
+// skipped when no service token is configured
+func (record *ownedClient) ghost(ctx context.Context, clientId string) *Client {
+	client := &Client{
+		Id:  clientId,
+		ctx: ctx,
+		auth: Auth{
+			authenticated: !record.Guest,
+			guest:         record.Guest,
+		},
+	}
+	if !record.Guest {
+		client.auth.user = &proto.User{Id: record.UserId}
+		if token := config.Map.Grpc.ServiceToken; token != "" {
+			client.auth.token = []byte(token)
+			client.auth.service = true
+		}
+	}
+	return client
+}
+
+// Get the theater room of this instance, or a room that is not served by
+// this instance when it has no clients of theater
+func (hub *TheaterHub) roomOf(theaterId string) *TheaterRoom {
+	if r, ok := hub.rooms.Get(theaterId); ok {
+		return r.(*TheaterRoom)
+	}
+	return &TheaterRoom{
+		hub:       hub,
+		theater:   &proto.Theater{Id: theaterId},
+		vp:        NewVideoPlayer(theaterId),
+		queue:     NewQueue(theaterId),
+		clients:   cmap.New(),
+		waiting:   cmap.New(),
+		drift:     newDriftStats(),
+		reactions: newReactions(),
+	}
+}
+
+// Remove a client of a dead instance from its room with the same side
+// effects as a disconnect, e.g. going offline or pausing an empty theater
+func release(ctx context.Context, users *UserHub, theaters *TheaterHub, clientId string, record *ownedClient) {
+	client := record.ghost(ctx, clientId)
+	switch record.Hub {
+	case TheaterRoomType.String():
+		client.roomType = TheaterRoomType
+		room := theaters.roomOf(record.Room)
+		client.room = room
+		if record.Waiting {
+			room.leaveWaiting(ctx, client)
+			return
+		}
+		room.Leave(client)
+	case UserRoomType.String():
+		if record.Guest {
+			return
+		}
+		client.roomType = UserRoomType
+		room := NewUserRoom(users, record.Room)
+		client.room = room
+		room.Leave(client)
+	}
+}
+
+// Clean up clients of instances that stopped sending heartbeats, janitor
+// sweeps on every heartbeat interval
+func Sweep(ctx context.Context, users *UserHub, theaters *TheaterHub) error {
+
+	// only one instance cleans up after dead instances at a time, the lock is
+	// extended while cleaning up so it doesn't expire in the middle of it
+	locked, err := redis.Client.SetNX(ctx, redis.Keys.JanitorLock(), instance.ID, instance.HeartbeatInterval).Result()
+	if err != nil || !locked {
+		return err
+	}
+	extendLock := func() {
+		redis.Client.Expire(ctx, redis.Keys.JanitorLock(), instance.HeartbeatInterval)
+	}
+
+	dead, err := instance.Dead(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, id := range dead {
+		clients, err := instance.Clients(ctx, id)
+		if err != nil {
+			return err
+		}
+		log.Printf("Cleaning up %d clients of dead instance [%s]", len(clients), id)
+		for clientId, data := range clients {
+			extendLock()
+			record := new(ownedClient)
+			if err := json.Unmarshal([]byte(data), record); err != nil {
+				log.Println(fmt.Errorf("could not read client [%s] of instance [%s]: %v", clientId, id, err))
+				continue
+			}
+			// the record is removed first, so the instance restores the client
+			// if it's alive after all
+			if released, err := instance.Release(ctx, id, clientId); err != nil || !released {
+				continue
+			}
+			release(ctx, users, theaters, clientId, record)
+		}
+		// instance is alive again, it restores its clients itself
+		if alive, err := instance.IsMember(ctx, id); err != nil || alive {
+			continue
+		}
+		if err := forgetGlobalSeats(ctx, id); err != nil {
+			return err
+		}
+		if err := instance.Forget(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Periodically clean up clients of crashed gateway instances
+func StartJanitor(ctx context.Context, users *UserHub, theaters *TheaterHub) {
+	go func() {
+		ticker := time.NewTicker(instance.HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := Sweep(ctx, users, theaters); err != nil {
+					sentry.CaptureException(fmt.Errorf("could not clean up dead instances: %v", err))
+				}
+			}
+		}
+	}()
+}
+
+// Restore seats and members of connected theater clients that were released
+// by the janitor of another instance
+func (hub *TheaterHub) Restore(ctx context.Context, owned map[string]string) {
+	hub.clients.IterCb(func(key string, v interface{}) {
+		client := v.(*Client)
+		room, ok := client.room.(*TheaterRoom)
+		if _, recorded := owned[client.Id]; recorded || !ok {
+			return
+		}
+		if room.isWaiting(client) {
+			own(ctx, client, true)
+			pipe := redis.Client.TxPipeline()
+			pipe.RPush(ctx, room.waitingKey(), client.Id)
+			pipe.SAdd(ctx, redis.Keys.TheatersWaiting(), room.GetName())
+			if _, err := pipe.Exec(ctx); err != nil {
+				sentry.CaptureException(err)
+			}
+			return
+		}
+		own(ctx, client, false)
+		seated, err := redis.Client.SAdd(ctx, theaterClientsKey(room.GetName()), client.Id).Result()
+		if err != nil {
+			sentry.CaptureException(err)
+			return
+		}
+		if seated == 1 {
+			redis.Client.HIncrBy(ctx, redis.Keys.TheaterSeats(), instance.ID, 1)
+		}
+		if !client.IsGuest() {
+			room.addMember(client)
+		}
+	})
+}
+
+// Record connected user clients again that were released by the janitor of
+// another instance, their users are online again
+func (hub *UserHub) Restore(ctx context.Context, owned map[string]string) {
+	hub.clients.IterCb(func(key string, v interface{}) {
+		client := v.(*Client)
+		room, ok := client.room.(*UserRoom)
+		if _, recorded := owned[client.Id]; recorded || !ok || client.IsGuest() {
+			return
+		}
+		hub.addClientToRoom(client)
+		room.UpdateState(client, proto.PERSONAL_STATE_ONLINE)
+	})
+}
@@ -7,6 +7,13 @@ const (
 	TheaterRoomType
 )
 
+func (t RoomType) String() string {
+	if t == TheaterRoomType {
+		return "theater"
+	}
+	return "user"
+}
+
 type Room interface {
 	GetType() RoomType
 	GetName() string
@@ -0,0 +1,119 @@
+package hub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/castyapp/gateway.server/redis"
+	cmap "github.com/orcaman/concurrent-map"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Types of system events
+const (
+	SystemAnnouncement = "announcement"
+)
+
+// SystemEvent is published to all gateway instances, empty filters match
+// every client
+type SystemEvent struct {
+	Type       string   `json:"type"`
+	Message    string   `json:"message"`
+	Hubs       []string `json:"hubs,omitempty"`
+	UserIds    []string `json:"user_ids,omitempty"`
+	TheaterIds []string `json:"theater_ids,omitempty"`
+}
+
+// Check if event should be delivered to client
+func (event *SystemEvent) matches(client *Client) bool {
+	if len(event.Hubs) > 0 && !contains(event.Hubs, client.roomType.String()) {
+		return false
+	}
+	if len(event.UserIds) > 0 && (!client.IsAuthenticated() || !contains(event.UserIds, client.GetUser().Id)) {
+		return false
+	}
+	if len(event.TheaterIds) > 0 {
+		if client.room == nil || client.room.GetType() != TheaterRoomType || !contains(event.TheaterIds, client.room.GetName()) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish a system event, returns number of gateway instances received it
+func PublishSystemEvent(ctx context.Context, event *SystemEvent) (int64, error) {
+	if event.Message == "" {
+		return 0, errors.New("system event message is required")
+	}
+	if event.Type == "" {
+		event.Type = SystemAnnouncement
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return 0, err
+	}
+	return redis.Client.Publish(ctx, redis.Keys.SystemEvents(), payload).Result()
+}
+
+// Create a notice event of gateway operators
+func newNotice(message string) (*structpb.Struct, error) {
+	return structpb.NewStruct(map[string]interface{}{
+		"message": message,
+	})
+}
+
+// Send an announcement as a notice to matching clients of this instance,
+// clients are copied out of the map so slow clients don't hold its lock
+func announce(clients cmap.ConcurrentMap, event *SystemEvent, notice *structpb.Struct) {
+	for _, v := range clients.Items() {
+		if client := v.(*Client); event.matches(client) {
+			_ = client.send(EMSG_SYSTEM_NOTICE, notice)
+		}
+	}
+}
+
+// Send an announcement to matching clients of user hub
+func (hub *UserHub) Announce(event *SystemEvent, notice *structpb.Struct) {
+	announce(hub.clients, event, notice)
+}
+
+// Send an announcement to matching clients of theater hub
+func (hub *TheaterHub) Announce(event *SystemEvent, notice *structpb.Struct) {
+	announce(hub.clients, event, notice)
+}
+
+// Listen on system events and fan them out to clients of both hubs, every
+// gateway process subscribes to system events once
+func ListenSystemEvents(ctx context.Context, users *UserHub, theaters *TheaterHub) {
+	err := redis.SubscribeContext(ctx, redis.Keys.SystemEvents(), func(payload string) {
+		event := new(SystemEvent)
+		if err := json.Unmarshal([]byte(payload), event); err != nil {
+			log.Println(fmt.Errorf("could not read system event REASON[%v]", err))
+			return
+		}
+		if event.Type != SystemAnnouncement {
+			return
+		}
+		notice, err := newNotice(event.Message)
+		if err != nil {
+			return
+		}
+		users.Announce(event, notice)
+		theaters.Announce(event, notice)
+	})
+	if err != nil {
+		log.Println(fmt.Errorf("could not subscribe to system events: %v", err))
+	}
+}
@@ -0,0 +1,240 @@
+package hub
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+
+	"github.com/castyapp/gateway.server/config"
+	"github.com/castyapp/gateway.server/grpc"
+	"github.com/castyapp/gateway.server/redis"
+	"github.com/castyapp/libcasty-protocol-go/proto"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Access modes of theaters that are enforced by gateway
+const (
+	AccessPublic     = "public"
+	AccessPassword   = "password"
+	AccessInviteCode = "invite_code"
+	AccessFriends    = "friends"
+)
+
+// Reasons clients are rejected from joining a theater
+const (
+	DeniedNotFound          = "not_found"
+	DeniedUnauthenticated   = "authentication_required"
+	DeniedInvalidPassword   = "invalid_password"
+	DeniedInvalidInviteCode = "invalid_invite_code"
+	DeniedFriendsOnly       = "friends_only"
+	DeniedAccessUnavailable = "access_unavailable"
+)
+
+// Credentials of a theater, clients send them with EMSG_THEATER_CREDENTIALS
+// before their logon event. Invited is set when the user accepted an invite
+// to the theater, which stands in for the password or invite code
+type Credentials struct {
+	Password   string
+	InviteCode string
+	Invited    bool
+}
+
+// AccessDeniedError is returned when a client can not join a theater, the
+// reason is sent to the client before closing its connection
+type AccessDeniedError struct {
+	Reason string
+}
+
+func (e *AccessDeniedError) Error() string {
+	return fmt.Sprintf("theater access denied: %s", e.Reason)
+}
+
+// TheaterAccess is the access mode of a theater
+type TheaterAccess struct {
+	Mode         string
+	PasswordHash string
+	InviteCode   string
+}
+
+func theaterAccessKey(theaterId string) string {
+	return redis.Keys.TheaterAccess(theaterId)
+}
+
+// Hash a theater password with bcrypt
+func HashTheaterPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// Check a password against its bcrypt hash
+func CheckTheaterPassword(hash, password string) bool {
+	return password != "" && bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// Read credentials of a EMSG_THEATER_CREDENTIALS event
+func readCredentials(event *structpb.Struct) Credentials {
+	return Credentials{
+		Password:   event.Fields["password"].GetStringValue(),
+		InviteCode: event.Fields["invite_code"].GetStringValue(),
+	}
+}
+
+// Get access mode of a theater, the mode set by owner takes precedence over
+// passwords in config and theater privacy
+func GetTheaterAccess(ctx context.Context, theater *proto.Theater) (*TheaterAccess, error) {
+
+	values, err := redis.Client.HGetAll(ctx, theaterAccessKey(theater.Id)).Result()
+	if err != nil {
+		return nil, err
+	}
+	if mode := values["mode"]; mode != "" {
+		return &TheaterAccess{
+			Mode:         mode,
+			PasswordHash: values["password_hash"],
+			InviteCode:   values["invite_code"],
+		}, nil
+	}
+
+	if hash, ok := config.Map.Theater.PasswordHashes[theater.Id]; ok {
+		return &TheaterAccess{Mode: AccessPassword, PasswordHash: hash}, nil
+	}
+
+	if theater.Privacy == proto.PRIVACY_FRIENDS {
+		return &TheaterAccess{Mode: AccessFriends}, nil
+	}
+
+	return &TheaterAccess{Mode: AccessPublic}, nil
+}
+
+// Check if an authenticated or guest client can join the theater
+func CheckTheaterAccess(ctx context.Context, theater *proto.Theater, auth Auth, credentials Credentials) error {
+
+	if auth.authenticated && auth.user.Id == theater.UserId {
+		return nil
+	}
+
+	access, err := GetTheaterAccess(ctx, theater)
+	if err != nil {
+		return &AccessDeniedError{Reason: DeniedAccessUnavailable}
+	}
+
+	if auth.authenticated && (access.Mode == AccessPassword || access.Mode == AccessInviteCode) {
+		credentials.Invited = IsTheaterInvited(ctx, theater.Id, auth.user.Id)
+	}
+
+	if err := access.CheckCredentials(auth.authenticated, credentials); err != nil {
+		return err
+	}
+
+	if access.Mode == AccessFriends {
+		response, err := grpc.UserServiceClient.GetFriends(ctx, &proto.AuthenticateRequest{
+			Token: auth.token,
+		})
+		if err != nil {
+			return &AccessDeniedError{Reason: DeniedAccessUnavailable}
+		}
+		for _, friend := range response.Result {
+			if friend.Id == theater.UserId {
+				return nil
+			}
+		}
+		return &AccessDeniedError{Reason: DeniedFriendsOnly}
+	}
+
+	return nil
+}
+
+// Check if a client with the given credentials can join a theater of this
+// access mode. Friendship is not checked here, friends only theaters only
+// require an authenticated client
+func (access *TheaterAccess) CheckCredentials(authenticated bool, credentials Credentials) error {
+
+	if access.Mode == AccessPublic {
+		return nil
+	}
+
+	if !authenticated {
+		return &AccessDeniedError{Reason: DeniedUnauthenticated}
+	}
+
+	if credentials.Invited && access.Mode != AccessFriends {
+		return nil
+	}
+
+	switch access.Mode {
+	case AccessPassword:
+		if !CheckTheaterPassword(access.PasswordHash, credentials.Password) {
+			return &AccessDeniedError{Reason: DeniedInvalidPassword}
+		}
+	case AccessInviteCode:
+		inviteCode := credentials.InviteCode
+		if inviteCode == "" || subtle.ConstantTimeCompare([]byte(inviteCode), []byte(access.InviteCode)) != 1 {
+			return &AccessDeniedError{Reason: DeniedInvalidInviteCode}
+		}
+	case AccessFriends:
+	default:
+		return &AccessDeniedError{Reason: DeniedAccessUnavailable}
+	}
+
+	return nil
+}
+
+// Tell client why it could not join the theater
+func rejectClient(client *Client, theaterId string, err error) {
+	reason := DeniedNotFound
+	if denied, ok := err.(*AccessDeniedError); ok {
+		reason = denied.Reason
+	}
+	rejection, err := structpb.NewStruct(map[string]interface{}{
+		"theater_id": theaterId,
+		"reason":     reason,
+	})
+	if err == nil {
+		_ = client.send(EMSG_THEATER_ACCESS_DENIED, rejection)
+	}
+}
+
+// Change access mode of theater, event has mode and the password or invite
+// code of the mode. an invite code is generated when it's not given
+func (room *TheaterRoom) SetAccess(ctx context.Context, event *structpb.Struct) (*TheaterAccess, error) {
+
+	access := &TheaterAccess{Mode: event.Fields["mode"].GetStringValue()}
+
+	switch access.Mode {
+	case AccessPublic, AccessFriends:
+	case AccessPassword:
+		password := event.Fields["password"].GetStringValue()
+		if password == "" {
+			return nil, fmt.Errorf("password is required for %s access", access.Mode)
+		}
+		hash, err := HashTheaterPassword(password)
+		if err != nil {
+			return nil, err
+		}
+		access.PasswordHash = hash
+	case AccessInviteCode:
+		access.InviteCode = event.Fields["invite_code"].GetStringValue()
+		if access.InviteCode == "" {
+			access.InviteCode = uuid.New().String()
+		}
+	default:
+		return nil, fmt.Errorf("invalid theater access mode: %s", access.Mode)
+	}
+
+	key := theaterAccessKey(room.GetName())
+	pipe := redis.Client.TxPipeline()
+	// invites accepted before the change do not grant the new mode
+	pipe.Del(ctx, key, theaterInvitedKey(room.GetName()))
+	pipe.HSet(ctx, key,
+		"mode", access.Mode,
+		"password_hash", access.PasswordHash,
+		"invite_code", access.InviteCode,
+	)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	return access, nil
+}
@@ -0,0 +1,319 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/castyapp/gateway.server/config"
+	"github.com/castyapp/gateway.server/instance"
+	"github.com/castyapp/gateway.server/redis"
+	"github.com/castyapp/libcasty-protocol-go/protocol"
+	"github.com/getsentry/sentry-go"
+	goredis "github.com/go-redis/redis/v8"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Seats a client if theater has a free seat and nobody is waiting before it.
+// KEYS: clients, waiting list. ARGV: client id, theater capacity.
+// Returns 1 if seated, 2 if it was seated already
+var takeSeatScript = goredis.NewScript(`
+if redis.call("SISMEMBER", KEYS[1], ARGV[1]) == 1 then
+	return 2
+end
+if redis.call("LLEN", KEYS[2]) > 0 then
+	return 0
+end
+local capacity = tonumber(ARGV[2])
+if capacity > 0 and redis.call("SCARD", KEYS[1]) >= capacity then
+	return 0
+end
+redis.call("SADD", KEYS[1], ARGV[1])
+return 1
+`)
+
+// Seats the first waiting client if there's a free seat and returns its id.
+// KEYS: clients, waiting list. ARGV: theater capacity
+var admitScript = goredis.NewScript(`
+local capacity = tonumber(ARGV[1])
+if capacity > 0 and redis.call("SCARD", KEYS[1]) >= capacity then
+	return false
+end
+local id = redis.call("LPOP", KEYS[2])
+if not id then
+	return false
+end
+redis.call("SADD", KEYS[1], id)
+return id
+`)
+
+// Reserves a seat of gateway for an instance if all instances together hold
+// less than the maximum seats.
+// KEYS: seats. ARGV: instance id, maximum seats. Returns 1 if reserved
+var reserveSeatScript = goredis.NewScript(`
+local max = tonumber(ARGV[2])
+if max > 0 then
+	local total = 0
+	for _, seats in ipairs(redis.call("HVALS", KEYS[1])) do
+		total = total + tonumber(seats)
+	end
+	if total >= max then
+		return 0
+	end
+end
+redis.call("HINCRBY", KEYS[1], ARGV[1], 1)
+return 1
+`)
+
+// Reserve a seat of gateway for a client of the given instance, returns false
+// when all seats are taken. Global seats can't share a slot with theater keys
+// in redis cluster, so they are reserved before taking a theater seat and
+// released if theater is full. Seats are counted per instance, so seats of a
+// dead instance are dropped with it by janitor
+func reserveGlobalSeat(ctx context.Context, instanceId string) (bool, error) {
+	reserved, err := reserveSeatScript.Run(ctx, redis.Client, []string{redis.Keys.TheaterSeats()},
+		instanceId,
+		config.Map.Theater.GlobalMaxClients,
+	).Int()
+	return reserved == 1, err
+}
+
+func releaseGlobalSeat(ctx context.Context, instanceId string) {
+	if err := redis.Client.HIncrBy(ctx, redis.Keys.TheaterSeats(), instanceId, -1).Err(); err != nil {
+		sentry.CaptureException(err)
+	}
+}
+
+// Move a reserved seat to the instance of the client that took it
+func moveGlobalSeat(ctx context.Context, from, to string) {
+	if from == to {
+		return
+	}
+	pipe := redis.Client.TxPipeline()
+	pipe.HIncrBy(ctx, redis.Keys.TheaterSeats(), from, -1)
+	pipe.HIncrBy(ctx, redis.Keys.TheaterSeats(), to, 1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		sentry.CaptureException(err)
+	}
+}
+
+// Drop seats of an instance, called once its clients are gone
+func forgetGlobalSeats(ctx context.Context, instanceId string) error {
+	return redis.Client.HDel(ctx, redis.Keys.TheaterSeats(), instanceId).Err()
+}
+
+// Get maximum clients of a theater, 0 is unlimited
+func theaterCapacity(theaterId string) int {
+	if capacity, ok := config.Map.Theater.MaxClientsOverrides[theaterId]; ok {
+		return capacity
+	}
+	return config.Map.Theater.MaxClients
+}
+
+func theaterClientsKey(theaterId string) string {
+	return redis.Keys.TheaterClients(theaterId)
+}
+
+func theaterWaitingKey(theaterId string) string {
+	return redis.Keys.TheaterWaiting(theaterId)
+}
+
+func (room *TheaterRoom) waitingKey() string {
+	return theaterWaitingKey(room.GetName())
+}
+
+func capacityKeys(theaterId string) []string {
+	return []string{theaterClientsKey(theaterId), theaterWaitingKey(theaterId)}
+}
+
+// Take a seat of theater for a client, returns false when theater or gateway
+// is full or others are waiting for a seat
+func TakeSeat(ctx context.Context, theaterId, clientId string) (bool, error) {
+	owner := instance.Owner(clientId)
+	reserved, err := reserveGlobalSeat(ctx, owner)
+	if err != nil || !reserved {
+		return false, err
+	}
+	seated, err := takeSeatScript.Run(ctx, redis.Client, capacityKeys(theaterId),
+		clientId,
+		theaterCapacity(theaterId),
+	).Int()
+	if err != nil || seated != 1 {
+		releaseGlobalSeat(ctx, owner)
+	}
+	return seated > 0, err
+}
+
+// Take a seat for client, returns false when theater or gateway is full
+func (room *TheaterRoom) takeSeat(ctx context.Context, client *Client) (bool, error) {
+	return TakeSeat(ctx, room.GetName(), client.Id)
+}
+
+// Refuse a client whose seat could not be checked, so capacity limits are
+// never skipped. Client is disconnected since it has no room
+func (room *TheaterRoom) refuseSeat(client *Client) {
+	client.room = nil
+	room.clients.Remove(client.Id)
+	room.hub.RemoveRoom(room.GetName())
+	event, err := structpb.NewStruct(map[string]interface{}{
+		"reason":      "capacity_unavailable",
+		"theater_id":  room.GetName(),
+		"unavailable": []interface{}{"redis"},
+	})
+	if err == nil {
+		_ = client.send(EMSG_SERVICE_UNAVAILABLE, event)
+	}
+	client.setDisconnectReason("capacity_unavailable")
+}
+
+// Free seat of client, returns true if client was seated
+func freeSeat(ctx context.Context, theaterId, clientId string) (bool, error) {
+	freed, err := redis.Client.SRem(ctx, theaterClientsKey(theaterId), clientId).Result()
+	if err != nil {
+		return false, err
+	}
+	if freed == 1 {
+		releaseGlobalSeat(ctx, instance.Owner(clientId))
+	}
+	return freed == 1, nil
+}
+
+// Admit a waiting client to a freed seat, the first one waiting for this
+// theater or for any theater when the seat was only limited by the gateway
+func seatFreed(ctx context.Context, theaterId string) {
+	if AdmitNext(ctx, theaterId) != "" || config.Map.Theater.GlobalMaxClients <= 0 {
+		return
+	}
+	theaters, err := redis.Client.SMembers(ctx, redis.Keys.TheatersWaiting()).Result()
+	if err != nil {
+		sentry.CaptureException(err)
+		return
+	}
+	for _, id := range theaters {
+		if id != theaterId && AdmitNext(ctx, id) != "" {
+			return
+		}
+	}
+}
+
+// Put client at the end of waiting list of theater
+func (room *TheaterRoom) wait(ctx context.Context, client *Client) error {
+	room.waiting.Set(client.Id, client)
+	room.clients.Remove(client.Id)
+	own(ctx, client, true)
+	pipe := redis.Client.TxPipeline()
+	pipe.RPush(ctx, room.waitingKey(), client.Id)
+	pipe.SAdd(ctx, redis.Keys.TheatersWaiting(), room.GetName())
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+	return room.sendWaitingPositions(ctx)
+}
+
+// Check if client is waiting for a seat
+func (room *TheaterRoom) isWaiting(client *Client) bool {
+	return room.waiting.Has(client.Id)
+}
+
+// Remove a waiting client that left before it was admitted, the client may
+// have been admitted at the same time so its seat is freed as well
+func (room *TheaterRoom) leaveWaiting(ctx context.Context, client *Client) {
+	room.waiting.Remove(client.Id)
+	if err := redis.Client.LRem(ctx, room.waitingKey(), 0, client.Id).Err(); err != nil {
+		sentry.CaptureException(err)
+	}
+	if freed, err := freeSeat(ctx, room.GetName(), client.Id); err == nil && freed {
+		seatFreed(ctx, room.GetName())
+	}
+	publishWaiting(ctx, room.GetName(), "")
+}
+
+// Admit the first waiting client of theater to a free seat, the instance that
+// holds the client's connection joins it to theater. Returns id of the
+// admitted client, empty if none was admitted
+func AdmitNext(ctx context.Context, theaterId string) string {
+	reserved, err := reserveGlobalSeat(ctx, instance.ID)
+	if err != nil || !reserved {
+		return ""
+	}
+	clientId, err := admitScript.Run(ctx, redis.Client, capacityKeys(theaterId),
+		theaterCapacity(theaterId),
+	).Text()
+	if err != nil {
+		releaseGlobalSeat(ctx, instance.ID)
+		if err != goredis.Nil {
+			sentry.CaptureException(fmt.Errorf("could not admit theater client: %v", err))
+			return ""
+		}
+		// theaters without waiting clients are forgotten lazily
+		if redis.Client.LLen(ctx, theaterWaitingKey(theaterId)).Val() == 0 {
+			redis.Client.SRem(ctx, redis.Keys.TheatersWaiting(), theaterId)
+		}
+		return ""
+	}
+	// client of a dead instance is not coming, the seat goes to the next one
+	owner := instance.Owner(clientId)
+	if alive, err := instance.IsMember(ctx, owner); err == nil && !alive {
+		if err := redis.Client.SRem(ctx, theaterClientsKey(theaterId), clientId).Err(); err != nil {
+			sentry.CaptureException(err)
+			return ""
+		}
+		releaseGlobalSeat(ctx, instance.ID)
+		return AdmitNext(ctx, theaterId)
+	}
+	moveGlobalSeat(ctx, instance.ID, owner)
+	publishWaiting(ctx, theaterId, clientId)
+	return clientId
+}
+
+// Tell room instances that a client is admitted, or positions changed when
+// client id is empty
+func publishWaiting(ctx context.Context, theaterId, clientId string) {
+	buffer, err := protocol.NewMsgProtobuf(EMSG_THEATER_ADMIT, wrapperspb.String(clientId))
+	if err != nil {
+		return
+	}
+	SendEventToTheaterRooms(ctx, theaterId, buffer.Bytes())
+}
+
+// Join the admitted client if it's waiting on this instance and update
+// positions of other waiting clients
+func (room *TheaterRoom) admit(ctx context.Context, packet *protocol.Packet) error {
+	admitted := new(wrapperspb.StringValue)
+	if err := packet.ReadProtoMsg(admitted); err != nil {
+		return err
+	}
+	if v, ok := room.waiting.Pop(admitted.Value); ok {
+		client := v.(*Client)
+		room.clients.Set(client.Id, client)
+		room.join(client)
+	}
+	if room.waiting.IsEmpty() {
+		return nil
+	}
+	return room.sendWaitingPositions(ctx)
+}
+
+// Send position in waiting list to waiting clients of this instance
+func (room *TheaterRoom) sendWaitingPositions(ctx context.Context) error {
+	ids, err := redis.Client.LRange(ctx, room.waitingKey(), 0, -1).Result()
+	if err != nil {
+		return err
+	}
+	for index, id := range ids {
+		v, ok := room.waiting.Get(id)
+		if !ok {
+			continue
+		}
+		position, err := structpb.NewStruct(map[string]interface{}{
+			"theater_id": room.GetName(),
+			"position":   index + 1,
+			"size":       len(ids),
+		})
+		if err != nil {
+			return err
+		}
+		_ = v.(*Client).send(EMSG_THEATER_WAITING, position)
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/castyapp/gateway.server/redis"
+	goredis "github.com/go-redis/redis/v8"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// TheaterHost keeps track of the member who is in control of a theater. It
+// is kept in redis so every gateway instance agrees on the host: the host,
+// the co-host and when each member joined.
+type TheaterHost struct {
+	ownerId    string
+	hostKey    string
+	coHostKey  string
+	joinedKey  string
+	membersKey string
+}
+
+func NewTheaterHost(theaterId, ownerId string) *TheaterHost {
+	return &TheaterHost{
+		ownerId:    ownerId,
+		hostKey:    redis.Keys.TheaterHost(theaterId),
+		coHostKey:  redis.Keys.TheaterCoHost(theaterId),
+		joinedKey:  redis.Keys.TheaterJoined(theaterId),
+		membersKey: redis.Keys.TheaterMembers(theaterId),
+	}
+}
+
+// Get user id of the member who is in control of theater, theater owner is
+// the host unless control is handed off to another member
+func (h *TheaterHost) Host(ctx context.Context) string {
+	host, err := redis.Client.Get(ctx, h.hostKey).Result()
+	if err != nil || host == "" {
+		return h.ownerId
+	}
+	return host
+}
+
+// Get user id of the co-host, empty if there's none
+func (h *TheaterHost) CoHost(ctx context.Context) string {
+	return redis.Client.Get(ctx, h.coHostKey).Val()
+}
+
+func (h *TheaterHost) isMember(ctx context.Context, userId string) bool {
+	return redis.Client.HExists(ctx, h.membersKey, userId).Val()
+}
+
+// Make user the host, returns true if host changed
+func (h *TheaterHost) set(ctx context.Context, userId string) (bool, error) {
+	previous, err := redis.Client.GetSet(ctx, h.hostKey, userId).Result()
+	if err != nil && err != goredis.Nil {
+		return false, err
+	}
+	return previous != userId, nil
+}
+
+// Designate a co-host that is promoted first when the host leaves
+func (h *TheaterHost) SetCoHost(ctx context.Context, userId string) error {
+	if userId == "" {
+		return redis.Client.Del(ctx, h.coHostKey).Err()
+	}
+	if !h.isMember(ctx, userId) {
+		return errors.New("co-host should be a member of theater")
+	}
+	return redis.Client.Set(ctx, h.coHostKey, userId, 0).Err()
+}
+
+// Hand control off to the co-host if present, otherwise to the member who
+// is watching theater for the longest time. Returns true if host changed
+func (h *TheaterHost) handoff(ctx context.Context) (bool, error) {
+
+	if coHost := h.CoHost(ctx); coHost != "" && h.isMember(ctx, coHost) {
+		return h.set(ctx, coHost)
+	}
+
+	oldest, err := redis.Client.ZRange(ctx, h.joinedKey, 0, 0).Result()
+	if err != nil {
+		return false, err
+	}
+	if len(oldest) == 0 {
+		// nobody left to hand off to, owner gets control back
+		return false, redis.Client.Del(ctx, h.hostKey).Err()
+	}
+
+	return h.set(ctx, oldest[0])
+}
+
+// Track when a member joined and keep theater under control of a present
+// member, owner takes control back when returns. Returns true if host changed
+func (h *TheaterHost) Joined(ctx context.Context, userId string) (bool, error) {
+
+	redis.Client.ZAddNX(ctx, h.joinedKey, &goredis.Z{
+		Score:  float64(time.Now().UnixNano()),
+		Member: userId,
+	})
+
+	if userId == h.ownerId {
+		return h.set(ctx, userId)
+	}
+
+	if !h.isMember(ctx, h.Host(ctx)) {
+		return h.handoff(ctx)
+	}
+
+	return false, nil
+}
+
+// Hand control off if the host left, called when a member's last client
+// left. Returns true if host changed
+func (h *TheaterHost) Left(ctx context.Context, userId string) (bool, error) {
+
+	if err := redis.Client.ZRem(ctx, h.joinedKey, userId).Err(); err != nil {
+		return false, err
+	}
+
+	if h.Host(ctx) != userId {
+		return false, nil
+	}
+
+	return h.handoff(ctx)
+}
+
+func (room *TheaterRoom) host() *TheaterHost {
+	return NewTheaterHost(room.GetName(), room.Theater().UserId)
+}
+
+// Get user id of the member who is in control of theater
+func (room *TheaterRoom) Host(ctx context.Context) string {
+	return room.host().Host(ctx)
+}
+
+// Check if client's user is in control of theater
+func (room *TheaterRoom) IsHost(ctx context.Context, client *Client) bool {
+	return !client.IsGuest() && client.GetUser().Id == room.Host(ctx)
+}
+
+// Check if client's user is the co-host of theater
+func (room *TheaterRoom) IsCoHost(ctx context.Context, client *Client) bool {
+	return !client.IsGuest() && client.GetUser().Id == room.host().CoHost(ctx)
+}
+
+// Designate a co-host that is promoted first when the host leaves
+func (room *TheaterRoom) SetCoHost(ctx context.Context, userId string) error {
+	return room.host().SetCoHost(ctx, userId)
+}
+
+// Announce the host to members if it changed
+func (room *TheaterRoom) hostChanged(ctx context.Context, host *TheaterHost, changed bool, err error) error {
+	if err != nil || !changed {
+		return err
+	}
+	room.sendPlayerEvent(ctx, EMSG_THEATER_HOST_CHANGED, wrapperspb.String(host.Host(ctx)))
+	return nil
+}
+
+// Keep theater under control of a present member when client's user joined
+func (room *TheaterRoom) hostJoined(ctx context.Context, client *Client) error {
+	host := room.host()
+	changed, err := host.Joined(ctx, client.GetUser().Id)
+	return room.hostChanged(ctx, host, changed, err)
+}
+
+// Hand control off if the host left, called when user's last client left
+func (room *TheaterRoom) hostLeft(ctx context.Context, client *Client) error {
+	host := room.host()
+	changed, err := host.Left(ctx, client.GetUser().Id)
+	return room.hostChanged(ctx, host, changed, err)
+}
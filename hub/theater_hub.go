@@ -2,11 +2,13 @@ package hub
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
+	"sync/atomic"
 
-	"github.com/castyapp/gateway.server/redis"
+	"github.com/castyapp/gateway.server/instance"
+	"github.com/castyapp/gateway.server/metrics"
 	"github.com/castyapp/libcasty-protocol-go/proto"
 	"github.com/getsentry/sentry-go"
 	"github.com/gobwas/ws"
@@ -18,42 +20,92 @@ import (
 type TheaterHub struct {
 	upgrader     websocket.Upgrader
 	VideoPlayers cmap.ConcurrentMap
-	clients      cmap.ConcurrentMap
+	// connected clients of this instance
+	clients cmap.ConcurrentMap
+	rooms   cmap.ConcurrentMap
+	// set when hub is shutting down, accessed atomically
+	draining int32
 }
 
-func (hub *TheaterHub) cleanUpClients() {
-	hub.clients.IterCb(func(key string, c interface{}) {
-		if client, ok := c.(ClientWithRoom); ok {
-			redis.Client.SRem(context.Background(), fmt.Sprintf("theater:clients:%s", client.Room), client.Id)
+func (hub *TheaterHub) Name() string {
+	return TheaterRoomType.String()
+}
+
+// Get number of connected clients of this instance
+func (hub *TheaterHub) Connections() int {
+	return hub.clients.Count()
+}
+
+// Stop reporting ready so new clients are sent to other instances
+func (hub *TheaterHub) Drain() {
+	atomic.StoreInt32(&hub.draining, 1)
+}
+
+func (hub *TheaterHub) Draining() bool {
+	return atomic.LoadInt32(&hub.draining) == 1
+}
+
+// Find a theater room that has clients on this gateway instance
+func (hub *TheaterHub) FindRoom(name string) (room Room, err error) {
+	if r, ok := hub.rooms.Get(name); ok {
+		return r.(*TheaterRoom), nil
+	}
+	return nil, errors.New("could not find theater room")
+}
+
+// Get the theater room of this instance or create it for the first client,
+// the client is registered to the room under the rooms lock so an empty room
+// can not be removed between getting and joining it
+func (hub *TheaterHub) getOrCreateRoom(theater *proto.Theater, client *Client) *TheaterRoom {
+	r := hub.rooms.Upsert(theater.Id, nil, func(exist bool, valueInMap, _ interface{}) interface{} {
+		room, ok := valueInMap.(*TheaterRoom)
+		if !exist || !ok {
+			room = NewTheaterRoom(hub, theater)
+			metrics.ActiveRooms.WithLabelValues(TheaterRoomType.String()).Inc()
 		}
+		room.clients.Set(client.Id, client)
+		return room
 	})
-	log.Println("Removed all clients from TheaterRooms!")
+	return r.(*TheaterRoom)
 }
 
-func (hub *TheaterHub) addClientToRoom(client *Client) {
-	hub.clients.SetIfAbsent(client.Id, ClientWithRoom{
-		Id:   client.Id,
-		Room: client.room.GetName(),
+// Remove the theater room if there's no client left on this instance
+func (hub *TheaterHub) RemoveRoom(name string) {
+	hub.rooms.RemoveCb(name, func(key string, v interface{}, exists bool) bool {
+		room, ok := v.(*TheaterRoom)
+		if !exists || !ok || !room.clients.IsEmpty() || !room.waiting.IsEmpty() {
+			return false
+		}
+		room.close()
+		metrics.ActiveRooms.WithLabelValues(TheaterRoomType.String()).Dec()
+		return true
 	})
+}
+
+func (hub *TheaterHub) cleanUpClients() {
+	closeClients(hub.clients)
+	// seats of clients that could not leave are released by janitor
 	ctx := context.Background()
-	clientsKey := fmt.Sprintf("theater:clients:%s", client.room.GetName())
-	exists := redis.Client.SIsMember(ctx, clientsKey, client.Id)
-	if !exists.Val() {
-		redis.Client.SAdd(ctx, clientsKey, client.Id)
+	if owned, err := instance.Clients(ctx, instance.ID); err == nil && len(owned) == 0 {
+		if err := forgetGlobalSeats(ctx, instance.ID); err != nil {
+			sentry.CaptureException(err)
+		}
 	}
+	log.Println("Removed all clients from TheaterRooms!")
 }
 
-func (hub *TheaterHub) removeClientFromRoom(client *Client) {
-	key := fmt.Sprintf("theater:clients:%s", client.room.GetName())
-	err := redis.Client.SRem(context.Background(), key, client.Id).Err()
+// Free seat of client, returns true if client was seated
+func (hub *TheaterHub) removeClientFromRoom(client *Client) bool {
+	freed, err := freeSeat(context.Background(), client.room.GetName(), client.Id)
 	if err != nil {
 		sentry.CaptureException(err)
-	} else {
-		hub.clients.Remove(client.Id)
+		return false
 	}
+	return freed
 }
 
 func (hub *TheaterHub) Close() error {
+	hub.Drain()
 	hub.cleanUpClients()
 	return nil
 }
@@ -61,6 +113,12 @@ func (hub *TheaterHub) Close() error {
 /* Get ws conn. and hands it over to correct room */
 func (hub *TheaterHub) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
+	// New clients are sent to other instances while draining
+	if hub.Draining() {
+		http.Error(w, "gateway is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
 	// Upgrade connection to websocket
 	conn, _, _, err := ws.UpgradeHTTP(req, w)
 	if err != nil {
@@ -72,6 +130,10 @@ func (hub *TheaterHub) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	log.Printf("[%s] New client connected", client.Id)
 
+	// Keep connected clients for clean up, admin api and system events
+	hub.clients.Set(client.Id, client)
+	defer hub.clients.Remove(client.Id)
+
 	// Close connection after client disconnected
 	defer client.Close()
 
@@ -86,16 +148,25 @@ func (hub *TheaterHub) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 		// getting theater from grpc service
 		theater, err = GetTheater(event.Room, auth.token)
+		if err == nil {
+			err = CheckTheaterAccess(client.ctx, theater, auth, client.credentials)
+		}
 
 		if err != nil {
+			rejectClient(client, string(event.Room), err)
+			client.setDisconnectReason("access_denied")
 			client.ctxCancel()
 			_ = client.Close()
 			return
 		}
 
-		room = NewTheaterRoom(hub, theater)
-		room.Join(client)
-		return
+		joined := hub.getOrCreateRoom(theater, client)
+		joined.Join(client)
+		// client is refused when its seat could not be taken
+		if client.room == nil {
+			return nil
+		}
+		return joined
 	})
 
 	// Listen on client events
@@ -109,5 +180,6 @@ func NewTheaterHub() *TheaterHub {
 		upgrader:     newUpgrader(),
 		VideoPlayers: cmap.New(),
 		clients:      cmap.New(),
+		rooms:        cmap.New(),
 	}
 }
@@ -0,0 +1,199 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/castyapp/gateway.server/grpc"
+	"github.com/castyapp/gateway.server/redis"
+	"github.com/castyapp/libcasty-protocol-go/proto"
+	"github.com/castyapp/libcasty-protocol-go/protocol"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Invitations are answered within this duration or they are forgotten
+const theaterInviteTTL = time.Hour
+
+// Accepted invitations let the invitee join a password or invite code
+// theater within this duration
+const theaterInvitedTTL = 24 * time.Hour
+
+func theaterInviteKey(inviteId string) string {
+	return redis.Keys.TheaterInvite(inviteId)
+}
+
+func theaterInvitedKey(theaterId string) string {
+	return redis.Keys.TheaterInvited(theaterId)
+}
+
+// Let a user that accepted an invite join the theater without its
+// credentials, expired invitees are removed on the way
+func acceptTheaterInvite(ctx context.Context, theaterId, userId string) error {
+	key := theaterInvitedKey(theaterId)
+	now := time.Now()
+	pipe := redis.Client.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Unix(), 10))
+	pipe.ZAdd(ctx, key, &goredis.Z{Score: float64(now.Add(theaterInvitedTTL).Unix()), Member: userId})
+	pipe.Expire(ctx, key, theaterInvitedTTL)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Check if user accepted an invite to theater that did not expire yet
+func IsTheaterInvited(ctx context.Context, theaterId, userId string) bool {
+	expiry, err := redis.Client.ZScore(ctx, theaterInvitedKey(theaterId), userId).Result()
+	return err == nil && int64(expiry) > time.Now().Unix()
+}
+
+// Publish an event to theater rooms of all gateway instances
+func SendEventToTheaterRooms(ctx context.Context, theaterId string, event []byte) {
+	redis.Client.Publish(ctx, redis.Keys.TheaterRoom(theaterId), event)
+}
+
+// Invite friends of client to theater, each friend gets a theater invite
+// notification on its user gateway. Every recipient is checked before any
+// invite is sent, and the inviter gets back friend_ids that were invited
+func (room *TheaterRoom) Invite(ctx context.Context, client *Client, request *proto.InviteFriendsTheaterRequest) error {
+
+	response, err := grpc.UserServiceClient.GetFriends(ctx, &proto.AuthenticateRequest{
+		Token: client.Token(),
+	})
+	if err != nil {
+		return err
+	}
+
+	friends := make(map[string]*proto.User, len(response.Result))
+	for _, friend := range response.Result {
+		friends[friend.Id] = friend
+	}
+
+	invitees := make([]*proto.User, 0, len(request.FriendIds))
+	for _, friendId := range request.FriendIds {
+		friend, ok := friends[friendId]
+		if !ok {
+			return fmt.Errorf("user [%s] is not a friend of inviter", friendId)
+		}
+		invitees = append(invitees, friend)
+	}
+
+	invited := make([]string, 0, len(invitees))
+	failed := make([]string, 0)
+	for _, friend := range invitees {
+		if err := room.invite(ctx, client.GetUser(), friend); err != nil {
+			failed = append(failed, friend.Id)
+			continue
+		}
+		invited = append(invited, friend.Id)
+	}
+
+	_ = client.send(EMSG_THEATER_INVITE, &proto.InviteFriendsTheaterRequest{
+		TheaterId: room.GetName(),
+		FriendIds: invited,
+	})
+
+	if len(failed) > 0 {
+		return fmt.Errorf("could not invite users %v to theater", failed)
+	}
+	return nil
+}
+
+// Store an invite and send its notification to the invitee
+func (room *TheaterRoom) invite(ctx context.Context, inviter, friend *proto.User) error {
+
+	inviteId := uuid.New().String()
+	key := theaterInviteKey(inviteId)
+	pipe := redis.Client.TxPipeline()
+	pipe.HSet(ctx, key,
+		"theater_id", room.GetName(),
+		"inviter_id", inviter.Id,
+		"invitee_id", friend.Id,
+	)
+	pipe.Expire(ctx, key, theaterInviteTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return err
+	}
+
+	buffer, err := protocol.NewMsgProtobuf(proto.EMSG_NEW_NOTIFICATION, &proto.NotificationMsgEvent{
+		Notification: &proto.Notification{
+			Id:         inviteId,
+			Type:       proto.Notification_NEW_THEATER_INVITE,
+			Data:       room.GetName(),
+			FromUserId: inviter.Id,
+			FromUser:   inviter,
+			ToUserId:   friend.Id,
+			CreatedAt:  ptypes.TimestampNow(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	SendEventToUser(ctx, buffer.Bytes(), friend)
+	return nil
+}
+
+// Answer a theater invite from the user gateway, the answer is delivered to
+// the inviter's theater clients. event has invite_id and accepted.
+func AnswerTheaterInvite(ctx context.Context, client *Client, event *structpb.Struct) error {
+
+	inviteId := event.Fields["invite_id"].GetStringValue()
+	invite, err := redis.Client.HGetAll(ctx, theaterInviteKey(inviteId)).Result()
+	if err != nil {
+		return err
+	}
+	if len(invite) == 0 {
+		return errors.New("could not find theater invite")
+	}
+	if invite["invitee_id"] != client.GetUser().Id {
+		return errors.New("theater invite belongs to another user")
+	}
+
+	if err := redis.Client.Del(ctx, theaterInviteKey(inviteId)).Err(); err != nil {
+		return err
+	}
+
+	accepted := event.Fields["accepted"].GetBoolValue()
+	if accepted {
+		if err := acceptTheaterInvite(ctx, invite["theater_id"], client.GetUser().Id); err != nil {
+			return err
+		}
+	}
+
+	answer, err := structpb.NewStruct(map[string]interface{}{
+		"invite_id":  inviteId,
+		"theater_id": invite["theater_id"],
+		"inviter_id": invite["inviter_id"],
+		"user_id":    client.GetUser().Id,
+		"accepted":   accepted,
+	})
+	if err != nil {
+		return err
+	}
+
+	buffer, err := protocol.NewMsgProtobuf(EMSG_THEATER_INVITE_ANSWER, answer)
+	if err != nil {
+		return err
+	}
+	SendEventToTheaterRooms(ctx, invite["theater_id"], buffer.Bytes())
+	return nil
+}
+
+// Deliver an invite answer to the inviter's clients on this gateway instance
+func (room *TheaterRoom) deliverInviteAnswer(packet *protocol.Packet) error {
+	answer := new(structpb.Struct)
+	if err := packet.ReadProtoMsg(answer); err != nil {
+		return err
+	}
+	inviterId := answer.Fields["inviter_id"].GetStringValue()
+	for _, v := range room.clients.Items() {
+		if client := v.(*Client); !client.IsGuest() && client.GetUser().Id == inviterId {
+			_ = client.send(EMSG_THEATER_INVITE_ANSWER, answer)
+		}
+	}
+	return nil
+}
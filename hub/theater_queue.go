@@ -0,0 +1,316 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/castyapp/gateway.server/grpc"
+	"github.com/castyapp/gateway.server/redis"
+	"github.com/castyapp/libcasty-protocol-go/proto"
+	"github.com/castyapp/libcasty-protocol-go/protocol"
+	"github.com/getsentry/sentry-go"
+	goredis "github.com/go-redis/redis/v8"
+	pb "github.com/golang/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Queue is the watch queue of a theater. It is kept in redis so any gateway
+// instance can serve the room: a list of media source ids in play order, a
+// hash of the media sources and the media source that is currently playing.
+type Queue struct {
+	key        string
+	itemsKey   string
+	currentKey string
+	advanceKey string
+}
+
+// Adds a media source to the end of queue unless it's queued already.
+// KEYS: queue, items. ARGV: media source id, media source.
+// Returns 0 if media source is in queue
+var queueAddScript = goredis.NewScript(`
+if redis.call("HSETNX", KEYS[2], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+redis.call("RPUSH", KEYS[1], ARGV[1])
+return 1
+`)
+
+// Pops the next media source of queue and makes it the current one, ids
+// without a media source are skipped.
+// KEYS: queue, items, current. Returns the media source or nil
+var queueNextScript = goredis.NewScript(`
+while true do
+	local id = redis.call("LPOP", KEYS[1])
+	if not id then
+		return false
+	end
+	local data = redis.call("HGET", KEYS[2], id)
+	if data then
+		redis.call("HDEL", KEYS[2], id)
+		redis.call("SET", KEYS[3], data)
+		return data
+	end
+end
+`)
+
+func NewQueue(theaterId string) *Queue {
+	return &Queue{
+		key:        redis.Keys.TheaterQueue(theaterId),
+		itemsKey:   redis.Keys.TheaterQueueItems(theaterId),
+		currentKey: redis.Keys.TheaterQueueCurrent(theaterId),
+		advanceKey: redis.Keys.TheaterQueueAdvance(theaterId),
+	}
+}
+
+// Get queue items in play order
+func (q *Queue) Items(ctx context.Context) ([]*proto.MediaSource, error) {
+	ids, err := redis.Client.LRange(ctx, q.key, 0, -1).Result()
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	values, err := redis.Client.HMGet(ctx, q.itemsKey, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*proto.MediaSource, 0, len(values))
+	for _, value := range values {
+		data, ok := value.(string)
+		if !ok {
+			continue
+		}
+		item := new(proto.MediaSource)
+		if err := pb.Unmarshal([]byte(data), item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// Add a media source to the end of queue
+func (q *Queue) Add(ctx context.Context, item *proto.MediaSource) error {
+	data, err := pb.Marshal(item)
+	if err != nil {
+		return err
+	}
+	added, err := queueAddScript.Run(ctx, redis.Client, []string{q.key, q.itemsKey}, item.Id, data).Int()
+	if err != nil {
+		return err
+	}
+	if added == 0 {
+		return errors.New("media source is already in queue")
+	}
+	return nil
+}
+
+// Remove a media source from queue
+func (q *Queue) Remove(ctx context.Context, id string) error {
+	pipe := redis.Client.TxPipeline()
+	pipe.LRem(ctx, q.key, 0, id)
+	pipe.HDel(ctx, q.itemsKey, id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Move a media source to the given index of queue
+func (q *Queue) Move(ctx context.Context, id string, index int) error {
+	return redis.Client.Watch(ctx, func(tx *goredis.Tx) error {
+		ids, err := tx.LRange(ctx, q.key, 0, -1).Result()
+		if err != nil {
+			return err
+		}
+		reordered := make([]interface{}, 0, len(ids))
+		for _, itemId := range ids {
+			if itemId != id {
+				reordered = append(reordered, itemId)
+			}
+		}
+		if len(reordered) == len(ids) {
+			return errors.New("media source is not in queue")
+		}
+		if index < 0 {
+			index = 0
+		}
+		if index > len(reordered) {
+			index = len(reordered)
+		}
+		reordered = append(reordered[:index], append([]interface{}{id}, reordered[index:]...)...)
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.Del(ctx, q.key)
+			pipe.RPush(ctx, q.key, reordered...)
+			return nil
+		})
+		return err
+	}, q.key)
+}
+
+// Pop the next media source of queue and make it the current one,
+// returns nil if queue is empty
+func (q *Queue) Next(ctx context.Context) (*proto.MediaSource, error) {
+	data, err := queueNextScript.Run(ctx, redis.Client, []string{q.key, q.itemsKey, q.currentKey}).Text()
+	if err == goredis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	item := new(proto.MediaSource)
+	return item, pb.Unmarshal([]byte(data), item)
+}
+
+// Get the media source that queue is playing, nil if theater is not
+// playing from queue
+func (q *Queue) Current(ctx context.Context) (*proto.MediaSource, error) {
+	data, err := redis.Client.Get(ctx, q.currentKey).Bytes()
+	if err == goredis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	item := new(proto.MediaSource)
+	return item, pb.Unmarshal(data, item)
+}
+
+// Stop playing from queue, theater's own media source is used again
+func (q *Queue) ClearCurrent(ctx context.Context) error {
+	return redis.Client.Del(ctx, q.currentKey).Err()
+}
+
+// Lock advancing past the given media source so only one gateway instance
+// advances the queue when it finishes
+func (q *Queue) lockAdvance(ctx context.Context, mediaSourceId string) bool {
+	return redis.Client.SetNX(ctx, q.advanceKey, mediaSourceId, 10*time.Second).Val()
+}
+
+// Send queue items to all theater members
+func (room *TheaterRoom) broadcastQueue(ctx context.Context) error {
+	items, err := room.queue.Items(ctx)
+	if err != nil {
+		return err
+	}
+	room.sendPlayerEvent(ctx, EMSG_THEATER_QUEUE, &proto.TheaterMediaSourcesResponse{Result: items})
+	return nil
+}
+
+// Add a media source to theater queue, the media source is fetched with the
+// client's token so members can only queue what they have access to
+func (room *TheaterRoom) AddToQueue(ctx context.Context, client *Client, item *proto.MediaSource) error {
+	response, err := grpc.TheaterServiceClient.GetMediaSource(ctx, &proto.MediaSourceAuthRequest{
+		Media: &proto.MediaSource{Id: item.Id},
+		AuthRequest: &proto.AuthenticateRequest{
+			Token: client.Token(),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if len(response.Result) == 0 {
+		return errors.New("could not find media source")
+	}
+	if err := room.queue.Add(ctx, response.Result[0]); err != nil {
+		return err
+	}
+	return room.broadcastQueue(ctx)
+}
+
+// Members may add to queue, only the host and co-host may remove or reorder
+func (room *TheaterRoom) canEditQueue(ctx context.Context, client *Client) bool {
+	return client.IsAuthenticated() && (room.IsHost(ctx, client) || room.IsCoHost(ctx, client))
+}
+
+// Remove a media source from theater queue
+func (room *TheaterRoom) RemoveFromQueue(ctx context.Context, item *proto.MediaSource) error {
+	if err := room.queue.Remove(ctx, item.Id); err != nil {
+		return err
+	}
+	return room.broadcastQueue(ctx)
+}
+
+// Move a media source of theater queue, event has media_source_id and index
+func (room *TheaterRoom) MoveInQueue(ctx context.Context, event *structpb.Struct) error {
+	id := event.Fields["media_source_id"].GetStringValue()
+	index := int(event.Fields["index"].GetNumberValue())
+	if err := room.queue.Move(ctx, id, index); err != nil {
+		return err
+	}
+	return room.broadcastQueue(ctx)
+}
+
+// Play the next media source of queue. Playing state is preserved, if queue
+// is empty and the current media finished the video player is paused.
+func (room *TheaterRoom) SkipQueue(ctx context.Context, finished bool) error {
+
+	state, err := room.vp.State()
+	if err != nil {
+		return err
+	}
+
+	next, err := room.queue.Next(ctx)
+	if err != nil {
+		return err
+	}
+
+	if next == nil {
+		if finished {
+			if state, err = room.vp.PauseNow(false); err != nil {
+				return err
+			}
+			room.sendPlayerEvent(ctx, proto.EMSG_THEATER_PAUSE, room.newTheaterVideoPlayer(state))
+			room.sendPlayerEvent(ctx, proto.EMSG_FINISHED_MOVIE, nil)
+		}
+		return nil
+	}
+
+	if err := room.vp.End(next.Id); err != nil {
+		return err
+	}
+	if state.Playing {
+		if _, err := room.vp.Play(0); err != nil {
+			return err
+		}
+	}
+
+	buffer, err := protocol.NewMsgProtobuf(proto.EMSG_THEATER_MEDIA_SOURCE_CHANGED, &proto.MediaSourceChangedEvent{
+		TheaterId:     room.GetName(),
+		MediaSourceId: next.Id,
+	})
+	if err != nil {
+		return err
+	}
+	room.SendEventToTheaterRooms(ctx, buffer.Bytes())
+
+	return room.broadcastQueue(ctx)
+}
+
+// Advance the queue when the clock reaches the duration of current media,
+// media source length is in seconds
+func (room *TheaterRoom) watchQueue() {
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-room.ctx.Done():
+			return
+		case <-ticker.C:
+			media := room.Theater().MediaSource
+			if media == nil || media.Length <= 0 {
+				continue
+			}
+			state, err := room.vp.State()
+			if err != nil || !state.Playing || state.CurrentTime(time.Now()) < float64(media.Length) {
+				continue
+			}
+			if !room.queue.lockAdvance(room.ctx, media.Id) {
+				continue
+			}
+			if err := room.SkipQueue(room.ctx, true); err != nil {
+				sentry.CaptureException(fmt.Errorf("could not advance theater queue: %v", err))
+			}
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package hub
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+
+	"github.com/castyapp/gateway.server/config"
+	"github.com/castyapp/gateway.server/ratelimit"
+	"github.com/castyapp/gateway.server/redis"
+	"github.com/castyapp/libcasty-protocol-go/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Names of rate limited theater events in config
+var rateLimitedEvents = map[proto.EMSG]string{
+	proto.EMSG_THEATER_PLAY:                 "play",
+	proto.EMSG_THEATER_PAUSE:                "pause",
+	EMSG_THEATER_SEEK:                       "seek",
+	EMSG_THEATER_PLAYBACK_RATE:              "rate",
+	proto.EMSG_NEW_CHAT_MESSAGE:             "chat",
+	EMSG_THEATER_REACTION:                   "reaction",
+	EMSG_THEATER_VOTE:                       "vote",
+	EMSG_THEATER_QUEUE_ADD:                  "queue",
+	EMSG_THEATER_QUEUE_REMOVE:               "queue",
+	EMSG_THEATER_QUEUE_MOVE:                 "queue",
+	EMSG_THEATER_QUEUE_SKIP:                 "queue",
+	EMSG_THEATER_INVITE:                     "invite",
+	proto.EMSG_THEATER_MEDIA_SOURCE_CHANGED: "media_source",
+}
+
+// Check rate limits of client and room for the event, the client is told
+// when to retry if any of them is exceeded. Limits are shared between gateway
+// instances, a user is limited across all of its clients
+func (room *TheaterRoom) allowEvent(ctx context.Context, client *Client, eMsg proto.EMSG) bool {
+
+	if !config.Map.RateLimit.Enabled {
+		return true
+	}
+
+	name, ok := rateLimitedEvents[eMsg]
+	if !ok {
+		return true
+	}
+
+	limits, ok := config.Map.RateLimit.Events[name]
+	if !ok {
+		return true
+	}
+
+	clientKey := redis.Keys.ClientRateLimit(client.Id, name)
+	if !client.IsGuest() {
+		clientKey = redis.Keys.UserRateLimit(client.GetUser().Id, name)
+	}
+
+	buckets := []struct {
+		key   string
+		limit ratelimit.Limit
+	}{
+		{clientKey, ratelimit.Limit{Rate: limits.Rate, Burst: limits.Burst}},
+		{redis.Keys.TheaterRateLimit(room.GetName(), name), ratelimit.Limit{Rate: limits.RoomRate, Burst: limits.RoomBurst}},
+	}
+
+	// buckets live in different redis slots, tokens taken before a bucket
+	// denies the event are given back
+	for i, bucket := range buckets {
+		allowed, retryAfter, err := ratelimit.Allow(ctx, bucket.key, bucket.limit)
+		if err != nil {
+			// do not block theaters when limits can't be checked
+			log.Println(fmt.Errorf("could not check rate limit: %v", err))
+			return true
+		}
+		if !allowed {
+			for _, taken := range buckets[:i] {
+				if err := ratelimit.Refund(ctx, taken.key, taken.limit); err != nil {
+					log.Println(fmt.Errorf("could not refund rate limit: %v", err))
+				}
+			}
+			throttled, err := structpb.NewStruct(map[string]interface{}{
+				"event":       name,
+				"retry_after": math.Ceil(retryAfter.Seconds()*1000) / 1000,
+			})
+			if err == nil {
+				_ = client.send(EMSG_THROTTLED, throttled)
+			}
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,191 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/castyapp/gateway.server/config"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	defaultReactionsPerSecond     = 5
+	defaultReactionsBatchMembers  = 50
+	reactionEmojiMaxLength        = 32
+	reactionsBatchInterval        = time.Second
+	reactionsLargeRoomCheckPeriod = 5 * time.Second
+)
+
+type reactionCount struct {
+	count        int
+	mediaTimeSum float64
+}
+
+// Reactions aggregates reactions of a large theater room on this gateway
+// instance, so they are published once per batch interval instead of once
+// per reaction. Small rooms publish every reaction right away.
+type Reactions struct {
+	mu      sync.Mutex
+	counts  map[string]*reactionCount
+	large   int32
+	clients sync.Map
+}
+
+type reactionWindow struct {
+	mu    sync.Mutex
+	start time.Time
+	count int
+}
+
+func newReactions() *Reactions {
+	return &Reactions{counts: make(map[string]*reactionCount)}
+}
+
+func reactionsPerSecond() int {
+	if limit := config.Map.Theater.ReactionsPerSecond; limit > 0 {
+		return limit
+	}
+	return defaultReactionsPerSecond
+}
+
+// Check if client is allowed to react, reactions are counted in one second
+// windows per client
+func (r *Reactions) allow(clientId string) bool {
+	v, _ := r.clients.LoadOrStore(clientId, &reactionWindow{})
+	window := v.(*reactionWindow)
+	window.mu.Lock()
+	defer window.mu.Unlock()
+	now := time.Now()
+	if now.Sub(window.start) >= time.Second {
+		window.start = now
+		window.count = 0
+	}
+	if window.count >= reactionsPerSecond() {
+		return false
+	}
+	window.count++
+	return true
+}
+
+func (r *Reactions) removeClient(clientId string) {
+	r.clients.Delete(clientId)
+}
+
+func (r *Reactions) isLarge() bool {
+	return atomic.LoadInt32(&r.large) == 1
+}
+
+func (r *Reactions) setLarge(large bool) {
+	var v int32
+	if large {
+		v = 1
+	}
+	atomic.StoreInt32(&r.large, v)
+}
+
+func (r *Reactions) add(emoji string, mediaTime float64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c, ok := r.counts[emoji]
+	if !ok {
+		c = new(reactionCount)
+		r.counts[emoji] = c
+	}
+	c.count++
+	c.mediaTimeSum += mediaTime
+}
+
+// Take aggregated reactions and reset the batch
+func (r *Reactions) flush() []interface{} {
+	r.mu.Lock()
+	counts := r.counts
+	r.counts = make(map[string]*reactionCount)
+	r.mu.Unlock()
+
+	emojis := make([]string, 0, len(counts))
+	for emoji := range counts {
+		emojis = append(emojis, emoji)
+	}
+	sort.Strings(emojis)
+
+	batch := make([]interface{}, 0, len(emojis))
+	for _, emoji := range emojis {
+		c := counts[emoji]
+		batch = append(batch, map[string]interface{}{
+			"emoji":      emoji,
+			"count":      c.count,
+			"media_time": c.mediaTimeSum / float64(c.count),
+		})
+	}
+	return batch
+}
+
+// React to theater media with an emoji, reactions are not persisted
+func (room *TheaterRoom) React(ctx context.Context, client *Client, event *structpb.Struct) error {
+
+	emoji := event.Fields["emoji"].GetStringValue()
+	if emoji == "" || len(emoji) > reactionEmojiMaxLength {
+		return errors.New("invalid reaction emoji")
+	}
+
+	if !room.reactions.allow(client.Id) {
+		return nil
+	}
+
+	mediaTime := event.Fields["media_time"].GetNumberValue()
+
+	if room.reactions.isLarge() {
+		room.reactions.add(emoji, mediaTime)
+		return nil
+	}
+
+	reaction, err := structpb.NewStruct(map[string]interface{}{
+		"emoji":      emoji,
+		"media_time": mediaTime,
+		"user_id":    client.GetUser().Id,
+	})
+	if err != nil {
+		return err
+	}
+	room.sendPlayerEvent(ctx, EMSG_THEATER_REACTION, reaction)
+	return nil
+}
+
+// Publish aggregated reactions of large rooms once per batch interval
+func (room *TheaterRoom) batchReactions() {
+
+	threshold := int64(config.Map.Theater.ReactionsBatchMembers)
+	if threshold <= 0 {
+		threshold = defaultReactionsBatchMembers
+	}
+
+	ticker := time.NewTicker(reactionsBatchInterval)
+	defer ticker.Stop()
+
+	lastCheck := time.Time{}
+	for {
+		select {
+		case <-room.ctx.Done():
+			return
+		case now := <-ticker.C:
+			if now.Sub(lastCheck) >= reactionsLargeRoomCheckPeriod {
+				lastCheck = now
+				room.reactions.setLarge(room.MembersCount(room.ctx) >= threshold)
+			}
+			batch := room.reactions.flush()
+			if len(batch) == 0 {
+				continue
+			}
+			reactions, err := structpb.NewStruct(map[string]interface{}{
+				"reactions": batch,
+			})
+			if err == nil {
+				room.sendPlayerEvent(room.ctx, EMSG_THEATER_REACTIONS, reactions)
+			}
+		}
+	}
+}
@@ -5,11 +5,20 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
+	"github.com/castyapp/gateway.server/cache"
+	"github.com/castyapp/gateway.server/config"
 	"github.com/castyapp/gateway.server/redis"
 	"github.com/castyapp/libcasty-protocol-go/protocol"
 	"github.com/getsentry/sentry-go"
+	goredis "github.com/go-redis/redis/v8"
+	pb "github.com/golang/protobuf/proto"
+	cmap "github.com/orcaman/concurrent-map"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 
 	"github.com/castyapp/gateway.server/grpc"
 	"github.com/castyapp/libcasty-protocol-go/proto"
@@ -19,8 +28,20 @@ type TheaterRoom struct {
 	hub *TheaterHub
 	// authorized theater
 	theater *proto.Theater
+	// guards theater since it is refreshed when media source changes
+	mu sync.RWMutex
 	// Video player configures
 	vp *VideoPlayer
+	// Watch queue of theater
+	queue *Queue
+	// clients of this room that are connected to this gateway instance
+	clients cmap.ConcurrentMap
+	// clients of this instance that are waiting for a seat
+	waiting   cmap.ConcurrentMap
+	drift     *DriftStats
+	reactions *Reactions
+	ctx       context.Context
+	ctxCancel context.CancelFunc
 }
 
 func (room *TheaterRoom) GetType() RoomType {
@@ -28,27 +49,66 @@ func (room *TheaterRoom) GetType() RoomType {
 }
 
 func (room *TheaterRoom) GetName() string {
-	return room.theater.Id
+	return room.Theater().Id
 }
 
-// Join a client to room
+// Get current snapshot of the theater
+func (room *TheaterRoom) Theater() *proto.Theater {
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+	return room.theater
+}
+
+func (room *TheaterRoom) setTheater(theater *proto.Theater) {
+	room.mu.Lock()
+	defer room.mu.Unlock()
+	room.theater = theater
+}
+
+// Check if client is the owner of theater
+func (room *TheaterRoom) IsOwner(client *Client) bool {
+	return !client.IsGuest() && client.GetUser().Id == room.Theater().UserId
+}
+
+// Join a client to room, client waits for a seat if theater is full
 func (room *TheaterRoom) Join(client *Client) {
 
 	// set current room to client
 	client.room = room
 
+	seated, err := room.takeSeat(context.Background(), client)
+	if err != nil {
+		sentry.CaptureException(fmt.Errorf("could not take theater seat: %v", err))
+		room.refuseSeat(client)
+		return
+	}
+	if !seated {
+		log.Printf("Client [%s] is waiting for a seat in Theater[%s]", client.Id, room.GetName())
+		if err := room.wait(context.Background(), client); err != nil {
+			sentry.CaptureException(err)
+		}
+		return
+	}
+
+	room.join(client)
+}
+
+// Join a seated client to room
+func (room *TheaterRoom) join(client *Client) {
+
+	own(context.Background(), client, false)
+
 	if !client.IsGuest() {
 
 		room.SubscribeEvents(client)
-		room.hub.addClientToRoom(client)
 
 		// Store theater members
-		//
-		//membersKey := fmt.Sprintf("theater:members:%s", room.theater.Id)
-		//memberExists := redis.Client.SIsMember(client.ctx, membersKey, client.Id)
-		//if !memberExists.Val() {
-		//	redis.Client.SAdd(client.ctx, membersKey, client.GetUser().Id)
-		//}
+		room.addMember(client)
+
+		// Keep theater under control of a present member
+		if err := room.hostJoined(context.Background(), client); err != nil {
+			sentry.CaptureException(err)
+		}
 
 		// Update user's activity to this theater
 		if err := room.updateUserActivity(client); err != nil {
@@ -57,13 +117,31 @@ func (room *TheaterRoom) Join(client *Client) {
 	}
 
 	if client.IsGuest() {
-		log.Printf("User [GUEST:%s] Theater[%s]", client.Id, room.theater.Id)
+		log.Printf("User [GUEST:%s] Theater[%s]", client.Id, room.GetName())
 	} else {
-		log.Printf("User [%s] Theater[%s]", client.GetUser().Id, room.theater.Id)
+		log.Printf("User [%s] Theater[%s]", client.GetUser().Id, room.GetName())
 	}
 
 	_ = client.send(proto.EMSG_AUTHORIZED, nil)
 
+	// estimate client's round trip time for precise playback scheduling
+	go client.probeTimeSync()
+
+	// send watch queue of theater
+	if items, err := room.queue.Items(context.Background()); err == nil && len(items) > 0 {
+		_ = client.send(EMSG_THEATER_QUEUE, &proto.TheaterMediaSourcesResponse{Result: items})
+	}
+
+	if room.IsDemocratic(context.Background()) {
+		_ = client.send(EMSG_THEATER_DEMOCRATIC_MODE, wrapperspb.Bool(true))
+	}
+
+	if start, err := room.ScheduledAt(context.Background()); err == nil && !start.IsZero() {
+		_ = client.send(EMSG_THEATER_SCHEDULE, timestamppb.New(start))
+	}
+
+	_ = client.send(EMSG_THEATER_HOST_CHANGED, wrapperspb.String(room.Host(context.Background())))
+
 	// get member from redis
 	//_ = client.send(proto.EMSG_THEATER_MEMBERS, &proto.TheaterMembers{
 	//	Members: room.GetMembers(),
@@ -75,47 +153,271 @@ func (room *TheaterRoom) Join(client *Client) {
 /* Removes client from room */
 func (room *TheaterRoom) Leave(client *Client) {
 
+	if room.isWaiting(client) {
+		room.leaveWaiting(context.Background(), client)
+		room.hub.RemoveRoom(room.GetName())
+		return
+	}
+
 	// removing client from redis and Theater's ConcurrentMap
-	room.hub.removeClientFromRoom(client)
+	if room.hub.removeClientFromRoom(client) {
+		// seat is free, admit the first waiting client
+		seatFreed(context.Background(), room.GetName())
+	}
 
-	if !client.IsGuest() {
-		// Remove user's activity
-		_ = room.removeUserActivity(client)
+	if !client.IsGuest() && room.removeMember(client) {
+		// Remove user's activity when user's last client left
+		if err := room.removeUserActivity(client); err != nil {
+			sentry.CaptureException(err)
+		}
+		// Hand control off if host left
+		if err := room.hostLeft(context.Background(), client); err != nil {
+			sentry.CaptureException(err)
+		}
 	}
 
-	key := fmt.Sprintf("theater:clients:%s", client.room.GetName())
-	clients := redis.Client.SMembers(context.Background(), key)
-	if len(clients.Val()) == 0 {
+	clients := redis.Client.SCard(context.Background(), theaterClientsKey(room.GetName()))
+	if clients.Val() == 0 {
+		// client can't be buffering anymore, nobody is left to resume for
+		if err := room.vp.SetBuffering(client.Id, false); err != nil {
+			sentry.CaptureException(err)
+		}
 		// pause VideoPlayer when there's no clients
-		room.vp.Pause()
+		if _, err := room.vp.PauseNow(false); err != nil {
+			sentry.CaptureException(err)
+		}
+	} else if err := room.SetBuffering(context.Background(), client, false); err != nil {
+		// client can't be buffering anymore, resume if others are waiting for it
+		sentry.CaptureException(err)
 	}
 
+	// remove room from hub if it was the last client of this instance
+	room.drift.remove(client.Id)
+	room.reactions.removeClient(client.Id)
+	room.clients.Remove(client.Id)
+	room.hub.RemoveRoom(room.GetName())
 }
 
-func (room *TheaterRoom) SubscribeEvents(client *Client) {
-	channel := fmt.Sprintf("theater:events:%s", room.theater.Id)
-	pubsub := redis.Client.Subscribe(client.ctx, channel)
-	go func() {
-		defer pubsub.Close()
-		for event := range pubsub.Channel() {
-			if err := client.WriteMessage([]byte(event.Payload)); err != nil {
-				log.Println(fmt.Errorf("could not write message to user's theater client REASON[%v]", err))
-				continue
+func (room *TheaterRoom) membersKey() string {
+	return redis.Keys.TheaterMembers(room.GetName())
+}
+
+// Members are kept as user id to number of connected clients of the user
+func (room *TheaterRoom) addMember(client *Client) {
+	redis.Client.HIncrBy(context.Background(), room.membersKey(), client.GetUser().Id, 1)
+}
+
+// Decrements clients of a member, the member is removed with its last client
+// and the count never goes below zero.
+// KEYS: members. ARGV: user id. Returns 1 if it was the last client of user
+var removeMemberScript = goredis.NewScript(`
+local count = tonumber(redis.call("HGET", KEYS[1], ARGV[1]) or "0")
+if count > 1 then
+	redis.call("HINCRBY", KEYS[1], ARGV[1], -1)
+	return 0
+end
+redis.call("HDEL", KEYS[1], ARGV[1])
+if count == 1 then
+	return 1
+end
+return 0
+`)
+
+// Returns true if it was the last client of the user
+func (room *TheaterRoom) removeMember(client *Client) bool {
+	last, err := removeMemberScript.Run(context.Background(), redis.Client,
+		[]string{room.membersKey()},
+		client.GetUser().Id,
+	).Int()
+	if err != nil {
+		sentry.CaptureException(fmt.Errorf("could not remove theater member: %v", err))
+		return false
+	}
+	return last == 1
+}
+
+// Get number of users that are watching theater
+func (room *TheaterRoom) MembersCount(ctx context.Context) int64 {
+	return redis.Client.HLen(ctx, room.membersKey()).Val()
+}
+
+// Listen on room events that are shared between gateway instances, backend
+// services may publish on this channel as well, e.g. a media source change
+func (room *TheaterRoom) listen() {
+	channel := redis.Keys.TheaterRoom(room.GetName())
+	err := redis.SubscribeContext(room.ctx, channel, func(payload string) {
+		packet, err := protocol.NewPacket([]byte(payload))
+		if err != nil {
+			log.Println(fmt.Errorf("could not read theater room event REASON[%v]", err))
+			return
+		}
+		switch packet.EMsg {
+		case proto.EMSG_THEATER_MEDIA_SOURCE_CHANGED:
+			if err := room.refreshMediaSource(); err != nil {
+				sentry.CaptureException(fmt.Errorf("could not refresh theater media source: %v", err))
+			}
+		case EMSG_THEATER_ADMIT:
+			if err := room.admit(room.ctx, packet); err != nil {
+				log.Println(err)
 			}
+		case EMSG_THEATER_INVITE_ANSWER:
+			if err := room.deliverInviteAnswer(packet); err != nil {
+				log.Println(err)
+			}
+		}
+	})
+	if err != nil {
+		sentry.CaptureException(fmt.Errorf("could not subscribe to theater room events: %v", err))
+	}
+}
+
+// Publish an event to theater rooms of all gateway instances
+func (room *TheaterRoom) SendEventToTheaterRooms(ctx context.Context, event []byte) {
+	SendEventToTheaterRooms(ctx, room.GetName(), event)
+}
+
+// Send an event to clients of this room on this gateway instance, clients
+// are copied out of the room so slow clients don't hold its lock
+func (room *TheaterRoom) sendToLocalClients(eMsg proto.EMSG, body pb.Message) {
+	for _, v := range room.clients.Items() {
+		if err := v.(*Client).send(eMsg, body); err != nil {
+			log.Println(fmt.Errorf("could not write message to theater client REASON[%v]", err))
+		}
+	}
+}
+
+// Get a token of an authenticated client of this room on this gateway instance
+func (room *TheaterRoom) memberToken() (token []byte) {
+	room.clients.IterCb(func(key string, v interface{}) {
+		if client := v.(*Client); token == nil && !client.IsGuest() {
+			token = client.Token()
+		}
+	})
+	return
+}
+
+// Change theater media source and notify rooms of all gateway instances
+func (room *TheaterRoom) ChangeMediaSource(ctx context.Context, client *Client, event *proto.MediaSourceChangedEvent) error {
+	theater := room.Theater()
+	if event.MediaSourceId != "" && (theater.MediaSource == nil || theater.MediaSource.Id != event.MediaSourceId) {
+		_, err := grpc.TheaterServiceClient.SelectMediaSource(ctx, &proto.MediaSourceAuthRequest{
+			Media: &proto.MediaSource{Id: event.MediaSourceId},
+			AuthRequest: &proto.AuthenticateRequest{
+				Token: client.Token(),
+			},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	// theater's own media source is played instead of the queue
+	if err := room.queue.ClearCurrent(ctx); err != nil {
+		return err
+	}
+	if err := cache.Theaters.Invalidate(ctx, theater.Id); err != nil {
+		sentry.CaptureException(err)
+	}
+	buffer, err := protocol.NewMsgProtobuf(proto.EMSG_THEATER_MEDIA_SOURCE_CHANGED, &proto.MediaSourceChangedEvent{
+		TheaterId:     theater.Id,
+		MediaSourceId: event.MediaSourceId,
+	})
+	if err != nil {
+		return err
+	}
+	room.SendEventToTheaterRooms(ctx, buffer.Bytes())
+	return nil
+}
+
+// Refresh theater from grpc service and tell local clients about the new
+// media source, the video player is reset here so media sources changed by
+// backend services start from the beginning as well
+func (room *TheaterRoom) refreshMediaSource() error {
+
+	theater, err := fetchTheater([]byte(room.GetName()), room.memberToken())
+	if err != nil {
+		return err
+	}
+
+	if err := room.applyQueue(theater); err != nil {
+		return err
+	}
+
+	if _, err := room.vp.Load(theater.MediaSource.GetId()); err != nil {
+		return err
+	}
+
+	state, err := room.vp.State()
+	if err != nil {
+		return err
+	}
+
+	room.setTheater(theater)
+
+	// clients get the same event as the room channel and are synced to the
+	// player with the new media source
+	room.sendToLocalClients(proto.EMSG_THEATER_MEDIA_SOURCE_CHANGED, &proto.MediaSourceChangedEvent{
+		TheaterId:     theater.Id,
+		MediaSourceId: theater.MediaSource.GetId(),
+	})
+	tvp := room.newTheaterVideoPlayer(state)
+	tvp.MediaSource = theater.MediaSource
+	room.sendToLocalClients(proto.EMSG_SYNCED, tvp)
+
+	updated := make(map[string]bool)
+	room.clients.IterCb(func(key string, v interface{}) {
+		client := v.(*Client)
+		if client.IsGuest() || updated[client.GetUser().Id] {
+			return
+		}
+		updated[client.GetUser().Id] = true
+		if err := room.updateUserActivity(client); err != nil {
+			sentry.CaptureException(err)
 		}
-	}()
+	})
+
+	return nil
+}
+
+// Replace theater's media source with the current queue item if the
+// theater is playing from its queue
+func (room *TheaterRoom) applyQueue(theater *proto.Theater) error {
+	current, err := room.queue.Current(context.Background())
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		theater.MediaSource = current
+	}
+	return nil
+}
+
+func (room *TheaterRoom) close() {
+	room.ctxCancel()
+}
+
+func (room *TheaterRoom) SubscribeEvents(client *Client) {
+	channel := redis.Keys.TheaterEvents(room.GetName())
+	err := redis.SubscribeContext(client.ctx, channel, func(payload string) {
+		if err := client.WriteMessage([]byte(payload)); err != nil {
+			log.Println(fmt.Errorf("could not write message to user's theater client REASON[%v]", err))
+		}
+	})
+	if err != nil {
+		sentry.CaptureException(fmt.Errorf("could not subscribe client to theater events: %v", err))
+	}
 }
 
 // updae user's activity to watching this theater
 func (room *TheaterRoom) updateUserActivity(client *Client) error {
 	if !client.IsGuest() {
 		mCtx := context.Background()
-		if room.theater.MediaSource != nil {
+		if theater := room.Theater(); theater.MediaSource != nil {
+			activity := &proto.Activity{
+				Id:       theater.Id,
+				Activity: theater.MediaSource.Title,
+			}
 			_, err := grpc.UserServiceClient.UpdateActivity(mCtx, &proto.UpdateActivityRequest{
-				Activity: &proto.Activity{
-					Id:       room.theater.Id,
-					Activity: room.theater.MediaSource.Title,
-				},
+				Activity: activity,
 				AuthRequest: &proto.AuthenticateRequest{
 					Token: client.Token(),
 				},
@@ -123,11 +425,13 @@ func (room *TheaterRoom) updateUserActivity(client *Client) error {
 			if err != nil {
 				return err
 			}
+			return SendActivityToFriends(mCtx, client, activity)
 		} else {
 			_, err := grpc.UserServiceClient.RemoveActivity(mCtx, &proto.AuthenticateRequest{Token: client.Token()})
 			if err != nil {
 				return err
 			}
+			return SendActivityToFriends(mCtx, client, nil)
 		}
 	}
 	return nil
@@ -135,11 +439,12 @@ func (room *TheaterRoom) updateUserActivity(client *Client) error {
 
 // Remove user's activity
 func (room *TheaterRoom) removeUserActivity(client *Client) error {
-	if !client.IsGuest() {
-		_, err := grpc.UserServiceClient.RemoveActivity(context.Background(), &proto.AuthenticateRequest{Token: client.Token()})
+	if mCtx, ok := client.userContext(context.Background()); ok {
+		_, err := grpc.UserServiceClient.RemoveActivity(mCtx, &proto.AuthenticateRequest{Token: client.Token()})
 		if err != nil {
 			return err
 		}
+		return SendActivityToFriends(mCtx, client, nil)
 	}
 	return nil
 }
@@ -148,29 +453,95 @@ func (room *TheaterRoom) Sync(client *Client) {
 
 	log.Printf("[%s] Syncing client...", client.Id)
 
-	var state proto.TheaterVideoPlayer_State
+	state, err := room.vp.State()
+	if err != nil {
+		sentry.CaptureException(fmt.Errorf("could not get theater video player state: %v", err))
+		return
+	}
 
-	log.Println("InProgress: ", room.vp.InProgress())
+	tvp := room.newTheaterVideoPlayer(state)
 
-	if room.vp.InProgress() {
-		state = proto.TheaterVideoPlayer_PLAYING
-	} else {
-		state = proto.TheaterVideoPlayer_PAUSED
+	log.Println("TVP: ", tvp)
+
+	_ = client.send(proto.EMSG_SYNCED, tvp)
+
+	if state.Rate != 1 {
+		_ = client.send(EMSG_THEATER_PLAYBACK_RATE, wrapperspb.Float(float32(state.Rate)))
 	}
+}
 
+// Create a TheaterVideoPlayer message from the current playback state,
+// sent_at is the server time that current_time belongs to
+func (room *TheaterRoom) newTheaterVideoPlayer(state *PlayerState) *proto.TheaterVideoPlayer {
+	now := time.Now()
 	tvp := &proto.TheaterVideoPlayer{
-		CurrentTime: room.vp.CurrentTime(),
-		State:       state,
+		TheaterId:   room.GetName(),
+		CurrentTime: float32(state.CurrentTime(now)),
+		State:       proto.TheaterVideoPlayer_PAUSED,
+		SentAt:      timestamppb.New(now),
 	}
+	if state.Playing {
+		tvp.State = proto.TheaterVideoPlayer_PLAYING
+	}
+	return tvp
+}
 
-	log.Println("TVP: ", tvp)
+// Check if enough theater clients are ready to play, the quorum is the
+// fraction of clients that should not be buffering
+func (room *TheaterRoom) isReady(ctx context.Context) bool {
+	total := redis.Client.SCard(ctx, theaterClientsKey(room.GetName())).Val()
+	if total == 0 {
+		return true
+	}
+	quorum := config.Map.Theater.ReadyQuorum
+	if quorum <= 0 || quorum > 1 {
+		quorum = 1
+	}
+	return float64(total-room.vp.Buffering())/float64(total) >= quorum
+}
 
-	_ = client.send(proto.EMSG_SYNCED, tvp)
+// Mark client as buffering or ready, the video player is paused while the
+// ready quorum is not reached and resumed when it is reached again
+func (room *TheaterRoom) SetBuffering(ctx context.Context, client *Client, buffering bool) error {
+
+	if err := room.vp.SetBuffering(client.Id, buffering); err != nil {
+		return err
+	}
+
+	state, err := room.vp.State()
+	if err != nil {
+		return err
+	}
+
+	ready := room.isReady(ctx)
+
+	switch {
+	case buffering && state.Playing && !ready:
+		if state, err = room.vp.PauseNow(true); err != nil {
+			return err
+		}
+		room.sendPlayerEvent(ctx, proto.EMSG_THEATER_PAUSE, room.newTheaterVideoPlayer(state))
+		room.sendPlayerEvent(ctx, proto.EMSG_WAITING_FOR_CLIENTS, nil)
+	case !buffering && state.AutoPaused && ready:
+		if state, err = room.vp.Play(float32(state.Position)); err != nil {
+			return err
+		}
+		room.sendPlayerEvent(ctx, proto.EMSG_THEATER_PLAY, room.newTheaterVideoPlayer(state))
+		room.sendPlayerEvent(ctx, proto.EMSG_CLIENTS_SYNCYED, nil)
+	}
 
+	return nil
+}
+
+func (room *TheaterRoom) sendPlayerEvent(ctx context.Context, eMsg proto.EMSG, body pb.Message) {
+	event, err := protocol.NewMsgProtobuf(eMsg, body)
+	if err == nil {
+		room.SendEventToTheaterMembers(ctx, event.Bytes())
+	}
 }
 
 func (room *TheaterRoom) SendEventToTheaterMembers(ctx context.Context, event []byte) {
-	redis.Client.Publish(ctx, fmt.Sprintf("theater:events:%s", room.theater.Id), event)
+	redis.Client.Publish(ctx, redis.Keys.TheaterEvents(room.GetName()), event)
 }
 
 // Handle client events
@@ -190,25 +561,50 @@ func (room *TheaterRoom) HandleEvents(client *Client) error {
 
 				log.Printf("NEW EVENT: [%s]", event.EMsg)
 
+				// waiting clients can't take part in theater until admitted
+				if room.isWaiting(client) && event.EMsg != proto.EMSG_PING {
+					continue
+				}
+
+				if !room.allowEvent(context.Background(), client, event.EMsg) {
+					continue
+				}
+
 				switch event.EMsg {
 
 				// syncing client to theater video player
 				case proto.EMSG_SYNC_ME:
 					room.Sync(client)
 
+				// client reported its playing position
+				case EMSG_THEATER_POSITION_REPORT:
+					report := new(proto.TheaterVideoPlayer)
+					if err := event.ReadProtoMsg(report); err == nil {
+						if err := room.ReportPosition(client, report); err != nil {
+							sentry.CaptureException(err)
+						}
+					}
+
+				// estimating clock offset and round trip time of client
+				case EMSG_TIME_SYNC:
+					if err := client.handleTimeSync(event); err != nil {
+						log.Println(err)
+					}
+
 				// when theater play requested
 				case proto.EMSG_THEATER_PLAY:
-					if client.IsAuthenticated() {
+					if room.canControl(context.Background(), client) {
 						mCtx := context.Background()
 						theaterVideoPlayer := new(proto.TheaterVideoPlayer)
 						if err := event.ReadProtoMsg(theaterVideoPlayer); err == nil {
 
-							room.vp.SetCurrentTime(theaterVideoPlayer.CurrentTime)
-
-							log.Println("CurrentTime: ", room.vp.CurrentTime())
-
 							log.Println("PLAY: ", theaterVideoPlayer)
-							room.vp.Play()
+							state, err := room.vp.Play(theaterVideoPlayer.CurrentTime)
+							if err != nil {
+								sentry.CaptureException(err)
+								continue
+							}
+							theaterVideoPlayer.SentAt = timestamppb.New(state.UpdatedAt)
 
 							event, err := protocol.NewMsgProtobuf(proto.EMSG_THEATER_PLAY, theaterVideoPlayer)
 							if err == nil {
@@ -220,15 +616,18 @@ func (room *TheaterRoom) HandleEvents(client *Client) error {
 
 				// when theater pause requested
 				case proto.EMSG_THEATER_PAUSE:
-					if client.IsAuthenticated() {
+					if room.canControl(context.Background(), client) {
 						mCtx := context.Background()
 						theaterVideoPlayer := new(proto.TheaterVideoPlayer)
 						if err := event.ReadProtoMsg(theaterVideoPlayer); err == nil {
 
-							room.vp.SetCurrentTime(theaterVideoPlayer.CurrentTime)
-
 							log.Println("PAUSE: ", theaterVideoPlayer)
-							room.vp.Pause()
+							state, err := room.vp.Pause(theaterVideoPlayer.CurrentTime)
+							if err != nil {
+								sentry.CaptureException(err)
+								continue
+							}
+							theaterVideoPlayer.SentAt = timestamppb.New(state.UpdatedAt)
 
 							event, err := protocol.NewMsgProtobuf(proto.EMSG_THEATER_PAUSE, theaterVideoPlayer)
 							if err == nil {
@@ -238,6 +637,207 @@ func (room *TheaterRoom) HandleEvents(client *Client) error {
 					}
 					break
 
+				// when theater seek requested
+				case EMSG_THEATER_SEEK:
+					if room.canControl(context.Background(), client) {
+						mCtx := context.Background()
+						theaterVideoPlayer := new(proto.TheaterVideoPlayer)
+						if err := event.ReadProtoMsg(theaterVideoPlayer); err == nil {
+							state, err := room.vp.Seek(theaterVideoPlayer.CurrentTime)
+							if err != nil {
+								sentry.CaptureException(err)
+								continue
+							}
+							tvp := room.newTheaterVideoPlayer(state)
+							tvp.UserId = client.GetUser().Id
+							room.sendPlayerEvent(mCtx, EMSG_THEATER_SEEK, tvp)
+						}
+					}
+					break
+
+				// when theater playback rate change requested
+				case EMSG_THEATER_PLAYBACK_RATE:
+					if room.canControl(context.Background(), client) {
+						mCtx := context.Background()
+						rate := new(wrapperspb.FloatValue)
+						if err := event.ReadProtoMsg(rate); err == nil {
+							if _, err := room.vp.SetRate(rate.Value); err != nil {
+								log.Println(err)
+								continue
+							}
+							room.sendPlayerEvent(mCtx, EMSG_THEATER_PLAYBACK_RATE, rate)
+						}
+					}
+					break
+
+				// when client is buffering or ready to play again
+				case proto.EMSG_BUFFERING, proto.EMSG_BUFFERED, proto.EMSG_CLIENT_READY:
+					if client.IsAuthenticated() {
+						buffering := event.EMsg == proto.EMSG_BUFFERING
+						if err := room.SetBuffering(context.Background(), client, buffering); err != nil {
+							sentry.CaptureException(err)
+						}
+					}
+					break
+
+				// when a media source added to theater queue
+				case EMSG_THEATER_QUEUE_ADD:
+					if client.IsAuthenticated() {
+						mCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+						item := new(proto.MediaSource)
+						if err := event.ReadProtoMsg(item); err == nil {
+							if err := room.AddToQueue(mCtx, client, item); err != nil {
+								log.Println(fmt.Errorf("could not add media source to theater queue: %v", err))
+							}
+						}
+						cancel()
+					}
+					break
+
+				// when a media source removed from theater queue
+				case EMSG_THEATER_QUEUE_REMOVE:
+					if room.canEditQueue(context.Background(), client) {
+						item := new(proto.MediaSource)
+						if err := event.ReadProtoMsg(item); err == nil {
+							if err := room.RemoveFromQueue(context.Background(), item); err != nil {
+								sentry.CaptureException(err)
+							}
+						}
+					}
+					break
+
+				// when a media source moved in theater queue
+				case EMSG_THEATER_QUEUE_MOVE:
+					if room.canEditQueue(context.Background(), client) {
+						move := new(structpb.Struct)
+						if err := event.ReadProtoMsg(move); err == nil {
+							if err := room.MoveInQueue(context.Background(), move); err != nil {
+								log.Println(fmt.Errorf("could not move media source in theater queue: %v", err))
+							}
+						}
+					}
+					break
+
+				// when skipping to the next media source of queue requested
+				case EMSG_THEATER_QUEUE_SKIP:
+					if room.canControl(context.Background(), client) {
+						if err := room.SkipQueue(context.Background(), false); err != nil {
+							sentry.CaptureException(err)
+						}
+					}
+					break
+
+				// when a member reacted to theater media
+				case EMSG_THEATER_REACTION:
+					if client.IsAuthenticated() {
+						reaction := new(structpb.Struct)
+						if err := event.ReadProtoMsg(reaction); err == nil {
+							if err := room.React(context.Background(), client, reaction); err != nil {
+								log.Println(err)
+							}
+						}
+					}
+					break
+
+				// when a member voted in democratic mode
+				case EMSG_THEATER_VOTE:
+					if client.IsAuthenticated() {
+						vote := new(structpb.Struct)
+						if err := event.ReadProtoMsg(vote); err == nil {
+							action := vote.Fields["action"].GetStringValue()
+							if err := room.Vote(context.Background(), client, action); err != nil {
+								log.Println(fmt.Errorf("could not vote in theater: %v", err))
+							}
+						}
+					}
+					break
+
+				// when theater owner toggled democratic mode
+				case EMSG_THEATER_DEMOCRATIC_MODE:
+					if client.IsAuthenticated() && room.IsOwner(client) {
+						enabled := new(wrapperspb.BoolValue)
+						if err := event.ReadProtoMsg(enabled); err == nil {
+							if err := room.SetDemocratic(context.Background(), enabled.Value); err != nil {
+								sentry.CaptureException(err)
+							}
+						}
+					}
+					break
+
+				// when a member invited friends to theater
+				case EMSG_THEATER_INVITE:
+					if client.IsAuthenticated() {
+						mCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+						invite := new(proto.InviteFriendsTheaterRequest)
+						if err := event.ReadProtoMsg(invite); err == nil {
+							if err := room.Invite(mCtx, client, invite); err != nil {
+								log.Println(fmt.Errorf("could not invite friends to theater: %v", err))
+							}
+						}
+						cancel()
+					}
+					break
+
+				// when theater owner designated a co-host
+				case EMSG_THEATER_COHOST:
+					if client.IsAuthenticated() && room.IsOwner(client) {
+						coHost := new(wrapperspb.StringValue)
+						if err := event.ReadProtoMsg(coHost); err == nil {
+							if err := room.SetCoHost(context.Background(), coHost.Value); err != nil {
+								log.Println(fmt.Errorf("could not set theater co-host: %v", err))
+							}
+						}
+					}
+					break
+
+				// when theater owner changed access mode of theater
+				case EMSG_THEATER_ACCESS:
+					if client.IsAuthenticated() && room.IsOwner(client) {
+						request := new(structpb.Struct)
+						if err := event.ReadProtoMsg(request); err == nil {
+							access, err := room.SetAccess(context.Background(), request)
+							if err != nil {
+								log.Println(fmt.Errorf("could not change theater access: %v", err))
+								continue
+							}
+							// password is never sent back, only the invite code to share
+							response, err := structpb.NewStruct(map[string]interface{}{
+								"mode":        access.Mode,
+								"invite_code": access.InviteCode,
+							})
+							if err == nil {
+								_ = client.send(EMSG_THEATER_ACCESS, response)
+							}
+						}
+					}
+					break
+
+				// when theater host scheduled a start time
+				case EMSG_THEATER_SCHEDULE:
+					if client.IsAuthenticated() && room.IsHost(context.Background(), client) {
+						startAt := new(timestamppb.Timestamp)
+						if err := event.ReadProtoMsg(startAt); err == nil {
+							if err := room.Schedule(context.Background(), startAt); err != nil {
+								log.Println(fmt.Errorf("could not schedule theater: %v", err))
+							}
+						}
+					}
+					break
+
+				// when theater host changed the media source
+				case proto.EMSG_THEATER_MEDIA_SOURCE_CHANGED:
+					if client.IsAuthenticated() && room.IsHost(context.Background(), client) {
+						mCtx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+						mediaSourceChanged := new(proto.MediaSourceChangedEvent)
+						if err := event.ReadProtoMsg(mediaSourceChanged); err == nil {
+							if err := room.ChangeMediaSource(mCtx, client, mediaSourceChanged); err != nil {
+								sentry.CaptureException(fmt.Errorf("could not change theater media source: %v", err))
+							}
+						}
+						cancel()
+					}
+					break
+
 				// when new message chat recieved
 				case proto.EMSG_NEW_CHAT_MESSAGE:
 					if client.IsAuthenticated() {
@@ -260,6 +860,15 @@ func (room *TheaterRoom) HandleEvents(client *Client) error {
 }
 
 func GetTheater(theaterId, token []byte) (*proto.Theater, error) {
+	theater := new(proto.Theater)
+	if cache.Theaters.Get(context.Background(), cache.TheaterKey(string(theaterId), token), theater) {
+		return theater, nil
+	}
+	return fetchTheater(theaterId, token)
+}
+
+// Get theater from grpc service and refresh the cache
+func fetchTheater(theaterId, token []byte) (*proto.Theater, error) {
 	req := &proto.GetTheaterRequest{
 		TheaterId: string(theaterId),
 	}
@@ -277,14 +886,32 @@ func GetTheater(theaterId, token []byte) (*proto.Theater, error) {
 	if response.Result == nil {
 		return nil, errors.New("could not find theater")
 	}
+	cache.Theaters.Set(mCtx, cache.TheaterKey(string(theaterId), token), response.Result)
 	return response.Result, nil
 }
 
 // create a new theater room
 func NewTheaterRoom(hub *TheaterHub, theater *proto.Theater) *TheaterRoom {
-	return &TheaterRoom{
-		hub:     hub,
-		theater: theater,
-		vp:      NewVideoPlayer(),
+	mCtx, cancel := context.WithCancel(context.Background())
+	room := &TheaterRoom{
+		hub:       hub,
+		theater:   theater,
+		vp:        NewVideoPlayer(theater.Id),
+		queue:     NewQueue(theater.Id),
+		clients:   cmap.New(),
+		waiting:   cmap.New(),
+		drift:     newDriftStats(),
+		reactions: newReactions(),
+		ctx:       mCtx,
+		ctxCancel: cancel,
+	}
+	if err := room.applyQueue(theater); err != nil {
+		sentry.CaptureException(err)
 	}
+	room.listen()
+	go room.broadcastPosition()
+	go room.watchQueue()
+	go room.batchReactions()
+	go room.watchSchedule()
+	return room
 }
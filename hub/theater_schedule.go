@@ -0,0 +1,134 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/castyapp/gateway.server/redis"
+	"github.com/castyapp/libcasty-protocol-go/proto"
+	"github.com/getsentry/sentry-go"
+	goredis "github.com/go-redis/redis/v8"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (room *TheaterRoom) scheduleKey() string {
+	return redis.Keys.TheaterSchedule(room.GetName())
+}
+
+// Get scheduled start time of theater, zero if nothing is scheduled
+func (room *TheaterRoom) ScheduledAt(ctx context.Context) (time.Time, error) {
+	value, err := redis.Client.Get(ctx, room.scheduleKey()).Result()
+	if err == goredis.Nil {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	ms, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, ms*int64(time.Millisecond)), nil
+}
+
+// Schedule theater to start playing at the given time, a zero timestamp
+// cancels the schedule
+func (room *TheaterRoom) Schedule(ctx context.Context, startAt *timestamppb.Timestamp) error {
+
+	if startAt.GetSeconds() == 0 && startAt.GetNanos() == 0 {
+		if err := redis.Client.Del(ctx, room.scheduleKey()).Err(); err != nil {
+			return err
+		}
+		room.sendPlayerEvent(ctx, EMSG_THEATER_SCHEDULE, &timestamppb.Timestamp{})
+		return nil
+	}
+
+	if err := startAt.CheckValid(); err != nil {
+		return err
+	}
+
+	start := startAt.AsTime()
+	if !start.After(time.Now()) {
+		return errors.New("scheduled start time should be in future")
+	}
+
+	ms := start.UnixNano() / int64(time.Millisecond)
+	if err := redis.Client.Set(ctx, room.scheduleKey(), ms, time.Until(start)+time.Hour).Err(); err != nil {
+		return err
+	}
+
+	room.sendPlayerEvent(ctx, EMSG_THEATER_SCHEDULE, startAt)
+	return nil
+}
+
+// Send a countdown to local clients every second until the scheduled time,
+// then one gateway instance starts playing from position zero
+func (room *TheaterRoom) watchSchedule() {
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-room.ctx.Done():
+			return
+		case now := <-ticker.C:
+			start, err := room.ScheduledAt(room.ctx)
+			if err != nil || start.IsZero() {
+				continue
+			}
+			if now.Before(start) {
+				countdown, err := structpb.NewStruct(map[string]interface{}{
+					"starts_at": unixMilli(start),
+					"remaining": start.Sub(now).Seconds(),
+				})
+				if err == nil {
+					room.sendToLocalClients(EMSG_THEATER_COUNTDOWN, countdown)
+				}
+				continue
+			}
+			if err := room.startSchedule(room.ctx, start); err != nil {
+				sentry.CaptureException(fmt.Errorf("could not start scheduled theater: %v", err))
+			}
+		}
+	}
+}
+
+// Claim the schedule of theater that starts at the given time, only the
+// first gateway instance that claims it gets true and the schedule is removed
+func ClaimSchedule(ctx context.Context, theaterId string, start time.Time) (bool, error) {
+	ms := start.UnixNano() / int64(time.Millisecond)
+	leaderKey := redis.Keys.TheaterScheduleLeader(theaterId, ms)
+	claimed, err := redis.Client.SetNX(ctx, leaderKey, 1, time.Minute).Result()
+	if err != nil || !claimed {
+		return false, err
+	}
+	return true, redis.Client.Del(ctx, redis.Keys.TheaterSchedule(theaterId)).Err()
+}
+
+// Start playing the scheduled theater, the first instance that claims the
+// schedule starts it so it fires once across all gateway instances
+func (room *TheaterRoom) startSchedule(ctx context.Context, start time.Time) error {
+
+	claimed, err := ClaimSchedule(ctx, room.GetName(), start)
+	if err != nil || !claimed {
+		return err
+	}
+
+	if err := room.vp.End(room.Theater().MediaSource.GetId()); err != nil {
+		return err
+	}
+
+	// clock starts at the scheduled time, not when this instance noticed it
+	state, err := room.vp.PlayAt(0, start)
+	if err != nil {
+		return err
+	}
+
+	room.sendPlayerEvent(ctx, proto.EMSG_THEATER_PLAY, room.newTheaterVideoPlayer(state))
+	return nil
+}
@@ -0,0 +1,238 @@
+package hub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/castyapp/gateway.server/config"
+	"github.com/castyapp/gateway.server/redis"
+	"github.com/castyapp/libcasty-protocol-go/proto"
+	"github.com/getsentry/sentry-go"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/types/known/structpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Actions members can vote for in democratic mode
+const (
+	VotePause  = "pause"
+	VoteResume = "resume"
+	VoteSkip   = "skip"
+)
+
+const (
+	defaultVoteThreshold = 0.5
+	defaultVoteTimeout   = 30 * time.Second
+)
+
+// Starts a vote if there's none for the action and counts the member's vote
+// in it, returns whether the vote was started, its id and number of votes
+var castVoteScript = goredis.NewScript(`
+local started = redis.call("HSETNX", KEYS[1], "id", ARGV[1])
+if started == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[3])
+end
+redis.call("HSET", KEYS[1], ARGV[2], 1)
+return {started, redis.call("HGET", KEYS[1], "id"), redis.call("HLEN", KEYS[1]) - 1}
+`)
+
+// Deletes a vote only if it is still the given one, so a vote is ended
+// exactly once either by passing or by timing out
+var endVoteScript = goredis.NewScript(`
+if redis.call("HGET", KEYS[1], "id") == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+func voteTimeout() time.Duration {
+	if timeout := config.Map.Theater.VoteTimeout; timeout > 0 {
+		return time.Duration(timeout) * time.Second
+	}
+	return defaultVoteTimeout
+}
+
+func voteThreshold() float64 {
+	if threshold := config.Map.Theater.VoteThreshold; threshold > 0 && threshold <= 1 {
+		return threshold
+	}
+	return defaultVoteThreshold
+}
+
+func (room *TheaterRoom) democraticKey() string {
+	return redis.Keys.TheaterDemocratic(room.GetName())
+}
+
+// Ballot is a vote for an action after a member's vote was counted
+type Ballot struct {
+	Id string
+	// set when the member's vote started it
+	Started bool
+	Votes   int64
+}
+
+// Check if theater is in democratic mode
+func (room *TheaterRoom) IsDemocratic(ctx context.Context) bool {
+	return redis.Client.Exists(ctx, room.democraticKey()).Val() == 1
+}
+
+// Check if client can control the video player directly, in democratic mode
+// only the owner can, members and hosts that took over should vote instead
+func (room *TheaterRoom) canControl(ctx context.Context, client *Client) bool {
+	return client.IsAuthenticated() && (room.IsOwner(client) || !room.IsDemocratic(ctx))
+}
+
+// Toggle democratic mode of theater
+func (room *TheaterRoom) SetDemocratic(ctx context.Context, enabled bool) error {
+	var err error
+	if enabled {
+		err = redis.Client.Set(ctx, room.democraticKey(), 1, 0).Err()
+	} else {
+		err = redis.Client.Del(ctx, room.democraticKey()).Err()
+	}
+	if err != nil {
+		return err
+	}
+	room.sendPlayerEvent(ctx, EMSG_THEATER_DEMOCRATIC_MODE, wrapperspb.Bool(enabled))
+	return nil
+}
+
+// Get number of votes required to pass a vote with a roster of members
+func RequiredVotes(members int64) int64 {
+	required := int64(math.Ceil(voteThreshold() * float64(members)))
+	if required < 1 {
+		required = 1
+	}
+	return required
+}
+
+// Count a user's vote for an action of theater, a vote is started if there's
+// none for the action and it's kept in redis for ttl
+func CastVote(ctx context.Context, theaterId, action, userId string, ttl time.Duration) (*Ballot, error) {
+	result, err := castVoteScript.Run(ctx, redis.Client,
+		[]string{redis.Keys.TheaterVote(theaterId, action)},
+		uuid.New().String(),
+		userId,
+		ttl.Milliseconds(),
+	).Result()
+	if err != nil {
+		return nil, err
+	}
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 3 {
+		return nil, fmt.Errorf("unexpected theater vote result: %v", result)
+	}
+	ballot := new(Ballot)
+	started, _ := values[0].(int64)
+	ballot.Started = started == 1
+	ballot.Id, _ = values[1].(string)
+	ballot.Votes, _ = values[2].(int64)
+	return ballot, nil
+}
+
+// End a vote of theater if it is still the given one, returns true if this
+// call ended it
+func EndVote(ctx context.Context, theaterId, action, voteId string) (bool, error) {
+	ended, err := endVoteScript.Run(ctx, redis.Client,
+		[]string{redis.Keys.TheaterVote(theaterId, action)},
+		voteId,
+	).Int()
+	return ended == 1, err
+}
+
+// Vote for an action, a vote starts with the first member's vote and passes
+// when the required votes are reached before it times out
+func (room *TheaterRoom) Vote(ctx context.Context, client *Client, action string) error {
+
+	if action != VotePause && action != VoteResume && action != VoteSkip {
+		return fmt.Errorf("invalid vote action: %s", action)
+	}
+
+	if !room.IsDemocratic(ctx) {
+		return errors.New("theater is not in democratic mode")
+	}
+
+	timeout := voteTimeout()
+	// keep the vote a bit longer than timeout so the timer can end it
+	ballot, err := CastVote(ctx, room.GetName(), action, client.GetUser().Id, timeout+5*time.Second)
+	if err != nil {
+		return err
+	}
+
+	// the vote is ended by its first voter's timer if it does not pass
+	if ballot.Started {
+		time.AfterFunc(timeout, func() {
+			room.endVote(context.Background(), action, ballot.Id, false)
+		})
+	}
+
+	required := RequiredVotes(room.MembersCount(ctx))
+
+	vote, err := structpb.NewStruct(map[string]interface{}{
+		"action":   action,
+		"user_id":  client.GetUser().Id,
+		"votes":    ballot.Votes,
+		"required": required,
+		"timeout":  timeout.Seconds(),
+	})
+	if err != nil {
+		return err
+	}
+	room.sendPlayerEvent(ctx, EMSG_THEATER_VOTE, vote)
+
+	if ballot.Votes >= required {
+		room.endVote(ctx, action, ballot.Id, true)
+	}
+
+	return nil
+}
+
+// End a vote and broadcast the result, the action is applied if it passed
+func (room *TheaterRoom) endVote(ctx context.Context, action, voteId string, passed bool) {
+
+	ended, err := EndVote(ctx, room.GetName(), action, voteId)
+	if err != nil || !ended {
+		return
+	}
+
+	if passed {
+		if err := room.applyVote(ctx, action); err != nil {
+			sentry.CaptureException(fmt.Errorf("could not apply theater vote: %v", err))
+		}
+	}
+
+	result, err := structpb.NewStruct(map[string]interface{}{
+		"action": action,
+		"passed": passed,
+	})
+	if err == nil {
+		room.sendPlayerEvent(ctx, EMSG_THEATER_VOTE_RESULT, result)
+	}
+}
+
+func (room *TheaterRoom) applyVote(ctx context.Context, action string) error {
+	switch action {
+	case VotePause:
+		state, err := room.vp.PauseNow(false)
+		if err != nil {
+			return err
+		}
+		room.sendPlayerEvent(ctx, proto.EMSG_THEATER_PAUSE, room.newTheaterVideoPlayer(state))
+	case VoteResume:
+		state, err := room.vp.State()
+		if err != nil {
+			return err
+		}
+		if state, err = room.vp.Play(float32(state.CurrentTime(time.Now()))); err != nil {
+			return err
+		}
+		room.sendPlayerEvent(ctx, proto.EMSG_THEATER_PLAY, room.newTheaterVideoPlayer(state))
+	case VoteSkip:
+		return room.SkipQueue(ctx, false)
+	}
+	return nil
+}
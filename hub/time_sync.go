@@ -0,0 +1,110 @@
+package hub
+
+import (
+	"log"
+	"sync/atomic"
+	"time"
+
+	"github.com/castyapp/gateway.server/config"
+	"github.com/castyapp/libcasty-protocol-go/protocol"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Fields of EMSG_TIME_SYNC payloads, all values are unix time in milliseconds
+const (
+	timeSyncClientTime        = "client_time"
+	timeSyncServerReceiveTime = "server_receive_time"
+	timeSyncServerSendTime    = "server_send_time"
+	timeSyncRTT               = "rtt"
+)
+
+const defaultTimeSyncInterval = 30 * time.Second
+
+func unixMilli(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Millisecond)
+}
+
+func fromUnixMilli(ms float64) time.Time {
+	return time.Unix(0, int64(ms*float64(time.Millisecond)))
+}
+
+// Get estimated round trip time of client
+func (c *Client) RTT() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.rtt))
+}
+
+// Smooth a round trip sample into the current estimate, negative samples
+// are ignored
+func SmoothRTT(current, sample time.Duration) time.Duration {
+	if sample < 0 {
+		return current
+	}
+	if current > 0 {
+		return (current*4 + sample) / 5
+	}
+	return sample
+}
+
+// Smooth a new round trip sample into the client's estimate
+func (c *Client) addRTTSample(sample time.Duration) {
+	atomic.StoreInt64(&c.rtt, int64(SmoothRTT(c.RTT(), sample)))
+}
+
+// Handle an EMSG_TIME_SYNC event. A request from client carries client_time
+// and is answered NTP style with the server receive and send times, so the
+// client can compute its clock offset. An answer to a server probe echoes
+// server_send_time and is used to estimate the client's round trip time.
+func (c *Client) handleTimeSync(packet *protocol.Packet) error {
+
+	received := time.Now()
+
+	event := new(structpb.Struct)
+	if err := packet.ReadProtoMsg(event); err != nil {
+		return err
+	}
+
+	if sent, ok := event.Fields[timeSyncServerSendTime]; ok {
+		c.addRTTSample(received.Sub(fromUnixMilli(sent.GetNumberValue())))
+		return nil
+	}
+
+	reply, err := structpb.NewStruct(map[string]interface{}{
+		timeSyncClientTime:        event.Fields[timeSyncClientTime].GetNumberValue(),
+		timeSyncServerReceiveTime: unixMilli(received),
+		timeSyncRTT:               float64(c.RTT()) / float64(time.Millisecond),
+	})
+	if err != nil {
+		return err
+	}
+
+	reply.Fields[timeSyncServerSendTime] = structpb.NewNumberValue(unixMilli(time.Now()))
+	return c.send(EMSG_TIME_SYNC, reply)
+}
+
+// Probe client periodically to estimate its round trip time
+func (c *Client) probeTimeSync() {
+
+	interval := time.Duration(config.Map.Theater.TimeSyncInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultTimeSyncInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		probe, err := structpb.NewStruct(map[string]interface{}{
+			timeSyncServerSendTime: unixMilli(time.Now()),
+		})
+		if err == nil {
+			if err := c.send(EMSG_TIME_SYNC, probe); err != nil {
+				log.Printf("[%s] could not send time sync probe: %v", c.Id, err)
+			}
+		}
+		select {
+		case <-c.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
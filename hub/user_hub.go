@@ -2,12 +2,14 @@ package hub
 
 import (
 	"context"
-	"fmt"
 	"log"
 	"net/http"
+	"sync/atomic"
 
+	"github.com/castyapp/gateway.server/grpc"
 	"github.com/castyapp/gateway.server/redis"
 	"github.com/castyapp/libcasty-protocol-go/proto"
+	"github.com/castyapp/libcasty-protocol-go/protocol"
 	"github.com/gobwas/ws"
 	"github.com/gorilla/websocket"
 	cmap "github.com/orcaman/concurrent-map"
@@ -15,44 +17,89 @@ import (
 
 /* Controls a bunch of rooms */
 type UserHub struct {
+	// connected clients of this instance
 	clients  cmap.ConcurrentMap
 	upgrader websocket.Upgrader
+	// set when hub is shutting down, accessed atomically
+	draining int32
+}
+
+func (hub *UserHub) Name() string {
+	return UserRoomType.String()
+}
+
+// Get number of connected clients of this instance
+func (hub *UserHub) Connections() int {
+	return hub.clients.Count()
+}
+
+// Stop reporting ready so new clients are sent to other instances
+func (hub *UserHub) Drain() {
+	atomic.StoreInt32(&hub.draining, 1)
+}
+
+func (hub *UserHub) Draining() bool {
+	return atomic.LoadInt32(&hub.draining) == 1
 }
 
 func SendEventToUser(ctx context.Context, event []byte, user *proto.User) {
-	redis.Client.Publish(ctx, fmt.Sprintf("user:events:%s", user.Id), event)
+	redis.Client.Publish(ctx, redis.Keys.UserEvents(user.Id), event)
 }
 
-func (hub *UserHub) cleanUpClients() {
-	hub.clients.IterCb(func(key string, c interface{}) {
-		if client, ok := c.(ClientWithRoom); ok {
-			redis.Client.SRem(context.Background(), fmt.Sprintf("user:clients:%s", client.Room), client.Id)
-		}
+// Tell friends of client's user about its new activity, activity is nil
+// when user stopped watching a theater
+func SendActivityToFriends(ctx context.Context, client *Client, activity *proto.Activity) error {
+
+	user := client.GetUser()
+	if user.State == proto.PERSONAL_STATE_INVISIBLE {
+		return nil
+	}
+
+	response, err := grpc.UserServiceClient.GetFriends(ctx, &proto.AuthenticateRequest{
+		Token: client.Token(),
 	})
+	if err != nil {
+		return err
+	}
+
+	buffer, err := protocol.NewMsgProtobuf(proto.EMSG_PERSONAL_ACTIVITY_CHANGED, &proto.PersonalActivityMsgEvent{
+		User:     user,
+		Activity: activity,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, friend := range response.Result {
+		SendEventToUser(ctx, buffer.Bytes(), friend)
+	}
+	return nil
+}
+
+func (hub *UserHub) cleanUpClients() {
+	closeClients(hub.clients)
 	log.Println("Removed all clients from UserRooms!")
 }
 
 func (hub *UserHub) addClientToRoom(client *Client) {
-	hub.clients.SetIfAbsent(client.Id, ClientWithRoom{
-		Id:   client.Id,
-		Room: client.room.GetName(),
-	})
 	ctx := context.Background()
-	key := fmt.Sprintf("user:clients:%s", client.room.GetName())
+	key := redis.Keys.UserClients(client.room.GetName())
 	if exists := redis.Client.SIsMember(ctx, key, client.Id); !exists.Val() {
 		redis.Client.SAdd(ctx, key, client.Id)
 	}
+	own(ctx, client, false)
 }
 
 func (hub *UserHub) removeClientFromRoom(client *Client) {
-	key := fmt.Sprintf("user:clients:%s", client.room.GetName())
-	if err := redis.Client.SRem(context.Background(), key, client.Id).Err(); err == nil {
-		hub.clients.Remove(client.Id)
+	key := redis.Keys.UserClients(client.room.GetName())
+	if err := redis.Client.SRem(context.Background(), key, client.Id).Err(); err != nil {
+		log.Println(err)
 	}
 }
 
 // Close user hub
 func (hub *UserHub) Close() error {
+	hub.Drain()
 	hub.cleanUpClients()
 	return nil
 }
@@ -60,6 +107,12 @@ func (hub *UserHub) Close() error {
 /* Get ws conn. and hands it over to correct room */
 func (hub *UserHub) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
+	// New clients are sent to other instances while draining
+	if hub.Draining() {
+		http.Error(w, "gateway is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
 	// Upgrade connection to websocket
 	conn, _, _, err := ws.UpgradeHTTP(req, w)
 	if err != nil {
@@ -71,6 +124,10 @@ func (hub *UserHub) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 
 	log.Printf("[%s] New client connected", client.Id)
 
+	// Keep connected clients for clean up, admin api and system events
+	hub.clients.Set(client.Id, client)
+	defer hub.clients.Remove(client.Id)
+
 	// Close connection after client disconnected
 	defer client.Close()
 
@@ -10,6 +10,7 @@ import (
 	"github.com/castyapp/libcasty-protocol-go/protocol"
 	"github.com/getsentry/sentry-go"
 	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/protobuf/types/known/structpb"
 
 	"github.com/castyapp/gateway.server/grpc"
 	"github.com/castyapp/libcasty-protocol-go/proto"
@@ -35,8 +36,8 @@ func (room *UserRoom) GetContext() context.Context {
 }
 
 func (room *UserRoom) UpdateState(client *Client, state proto.PERSONAL_STATE) {
-	if !client.IsGuest() {
-		_, err := grpc.UserServiceClient.UpdateState(context.Background(), &proto.UpdateStateRequest{
+	if mCtx, ok := client.userContext(context.Background()); ok {
+		_, err := grpc.UserServiceClient.UpdateState(mCtx, &proto.UpdateStateRequest{
 			State:       state,
 			AuthRequest: &proto.AuthenticateRequest{Token: client.Token()},
 		})
@@ -48,25 +49,16 @@ func (room *UserRoom) UpdateState(client *Client, state proto.PERSONAL_STATE) {
 
 func (room *UserRoom) SubscribeEvents(client *Client) {
 	if !client.IsGuest() {
-		channel := fmt.Sprintf("user:events:%s", client.GetUser().Id)
-		pubsub := redis.Client.Subscribe(context.Background(), channel)
-		go func() {
-			for {
-				select {
-				case <-client.ctx.Done():
-					if err := pubsub.Unsubscribe(context.Background(), channel); err != nil {
-						log.Println(fmt.Errorf("could not unsubscribe user from its redis events REASON[%v]", err))
-					}
-					pubsub.Close() // close redis pubsub for user
-					return
-				case event := <-pubsub.Channel():
-					if err := client.WriteMessage([]byte(event.Payload)); err != nil {
-						log.Println(fmt.Errorf("could not write message to user client REASON[%v]", err))
-						continue
-					}
-				}
+		channel := redis.Keys.UserEvents(client.GetUser().Id)
+		// unsubscribed when client disconnected
+		err := redis.SubscribeContext(client.ctx, channel, func(payload string) {
+			if err := client.WriteMessage([]byte(payload)); err != nil {
+				log.Println(fmt.Errorf("could not write message to user client REASON[%v]", err))
 			}
-		}()
+		})
+		if err != nil {
+			sentry.CaptureException(fmt.Errorf("could not subscribe user to its redis events: %v", err))
+		}
 	}
 }
 
@@ -88,7 +80,7 @@ func (room *UserRoom) Join(client *Client) {
 		room.UpdateState(client, proto.PERSONAL_STATE_ONLINE)
 	}
 
-	if err := protocol.BrodcastMsgProtobuf(client.conn, proto.EMSG_AUTHORIZED, nil); err != nil {
+	if err := client.send(proto.EMSG_AUTHORIZED, nil); err != nil {
 		log.Println(err)
 		sentry.CaptureException(fmt.Errorf("could not send Authorized message to user: %v", err))
 	}
@@ -99,7 +91,7 @@ func (room *UserRoom) Leave(client *Client) {
 	// removing client from redis and User's ConccurentMap
 	room.hub.removeClientFromRoom(client)
 
-	key := fmt.Sprintf("user:clients:%s", client.GetUser().Id)
+	key := redis.Keys.UserClients(client.GetUser().Id)
 	if clients := redis.Client.SMembers(context.Background(), key).Val(); len(clients) == 0 {
 		// Set a OFFLINE state for user if there's no client left
 		room.UpdateState(client, proto.PERSONAL_STATE_OFFLINE)
@@ -171,6 +163,20 @@ func (room *UserRoom) HandleEvents(client *Client) error {
 					}
 					break
 
+				// when user answered a theater invite
+				case EMSG_THEATER_INVITE_ANSWER:
+					if client.IsAuthenticated() {
+						answer := new(structpb.Struct)
+						if err := event.ReadProtoMsg(answer); err != nil {
+							log.Println(err)
+							continue
+						}
+						if err := AnswerTheaterInvite(context.Background(), client, answer); err != nil {
+							log.Println(err)
+							continue
+						}
+					}
+
 				// when user sending a new message
 				case proto.EMSG_NEW_CHAT_MESSAGE:
 					if client.IsAuthenticated() {
@@ -1,50 +1,256 @@
 package hub
 
-import "github.com/castyapp/libcasty-protocol-go/proto"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
 
+	"github.com/castyapp/gateway.server/redis"
+	goredis "github.com/go-redis/redis/v8"
+)
+
+// Bounds of playback rate
+const (
+	minPlaybackRate = 0.25
+	maxPlaybackRate = 4
+)
+
+// Times a change of playback state is retried when other instances changed
+// it at the same time
+const playerUpdateRetries = 10
+
+// PlayerState is the authoritative playback state of a theater, position is
+// the media time at UpdatedAt so the clock does not need a ticker
+type PlayerState struct {
+	Playing    bool
+	Position   float64
+	Rate       float64
+	UpdatedAt  time.Time
+	AutoPaused bool
+	// media source the clock was reset for
+	MediaSourceId string
+}
+
+// Get media position at the given time
+func (s *PlayerState) CurrentTime(at time.Time) float64 {
+	if !s.Playing {
+		return s.Position
+	}
+	return s.Position + at.Sub(s.UpdatedAt).Seconds()*s.Rate
+}
+
+// VideoPlayer keeps the playback state of a theater in redis so every
+// gateway instance serves the same clock
 type VideoPlayer struct {
-	t       *Timer
-	Theater *proto.Theater
+	key          string
+	bufferingKey string
 }
 
-func NewVideoPlayer() *VideoPlayer {
-	timer := &Timer{
-		sendC:     make(chan float32),
-		startChan: make(chan struct{}),
-		closeChan: make(chan struct{}),
+func NewVideoPlayer(theaterId string) *VideoPlayer {
+	return &VideoPlayer{
+		key:          redis.Keys.TheaterPlayer(theaterId),
+		bufferingKey: redis.Keys.TheaterBuffering(theaterId),
 	}
-	go timer.Listen()
-	return &VideoPlayer{t: timer}
 }
 
-func (vp *VideoPlayer) InProgress() bool {
-	return vp.t.inProgress
+// Get current playback state
+func (vp *VideoPlayer) State() (*PlayerState, error) {
+	values, err := redis.Client.HGetAll(context.Background(), vp.key).Result()
+	if err != nil {
+		return nil, err
+	}
+	return parsePlayerState(values), nil
 }
 
-func (vp *VideoPlayer) SetCurrentTime(currentTime float32) {
-	vp.t.ct = currentTime
+func parsePlayerState(values map[string]string) *PlayerState {
+	state := &PlayerState{Rate: 1}
+	if v, ok := values["playing"]; ok {
+		state.Playing = v == "1"
+	}
+	if v, ok := values["position"]; ok {
+		state.Position, _ = strconv.ParseFloat(v, 64)
+	}
+	if v, ok := values["rate"]; ok {
+		if rate, err := strconv.ParseFloat(v, 64); err == nil && rate > 0 {
+			state.Rate = rate
+		}
+	}
+	if v, ok := values["updated_at"]; ok {
+		ms, _ := strconv.ParseInt(v, 10, 64)
+		state.UpdatedAt = time.Unix(0, ms*int64(time.Millisecond))
+	}
+	if v, ok := values["auto_paused"]; ok {
+		state.AutoPaused = v == "1"
+	}
+	state.MediaSourceId = values["media_source_id"]
+	return state
+}
+
+func (state *PlayerState) fields() []interface{} {
+	return []interface{}{
+		"playing", state.Playing,
+		"position", state.Position,
+		"rate", state.Rate,
+		"updated_at", state.UpdatedAt.UnixNano() / int64(time.Millisecond),
+		"auto_paused", state.AutoPaused,
+		"media_source_id", state.MediaSourceId,
+	}
+}
+
+func (vp *VideoPlayer) save(state *PlayerState) error {
+	return redis.Client.HSet(context.Background(), vp.key, state.fields()...).Err()
+}
+
+// Change playback state atomically, the change is applied again to the new
+// state when another instance changed it at the same time
+func (vp *VideoPlayer) update(change func(state *PlayerState)) (*PlayerState, error) {
+	ctx := context.Background()
+	var state *PlayerState
+	txf := func(tx *goredis.Tx) error {
+		values, err := tx.HGetAll(ctx, vp.key).Result()
+		if err != nil {
+			return err
+		}
+		state = parsePlayerState(values)
+		change(state)
+		_, err = tx.TxPipelined(ctx, func(pipe goredis.Pipeliner) error {
+			pipe.HSet(ctx, vp.key, state.fields()...)
+			return nil
+		})
+		return err
+	}
+	for i := 0; i < playerUpdateRetries; i++ {
+		err := redis.Client.Watch(ctx, txf, vp.key)
+		if err == goredis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return state, nil
+	}
+	return nil, errors.New("could not change theater video player, too many concurrent changes")
 }
 
-func (vp *VideoPlayer) Timer() *Timer {
-	return vp.t
+func (vp *VideoPlayer) InProgress() bool {
+	state, err := vp.State()
+	if err != nil {
+		return false
+	}
+	return state.Playing
 }
 
 func (vp *VideoPlayer) CurrentTime() float32 {
-	return vp.t.ct
+	state, err := vp.State()
+	if err != nil {
+		return 0
+	}
+	return float32(state.CurrentTime(time.Now()))
+}
+
+// Play from the given position
+func (vp *VideoPlayer) Play(currentTime float32) (*PlayerState, error) {
+	return vp.PlayAt(currentTime, time.Now())
 }
 
-func (vp *VideoPlayer) CurrentTimeChan() <-chan float32 {
-	return vp.t.sendC
+// Play from the given position as if playing started at the given time
+func (vp *VideoPlayer) PlayAt(currentTime float32, at time.Time) (*PlayerState, error) {
+	return vp.update(func(state *PlayerState) {
+		state.Playing = true
+		state.Position = float64(currentTime)
+		state.UpdatedAt = at
+		state.AutoPaused = false
+	})
 }
 
-func (vp *VideoPlayer) Play() {
-	go vp.t.start()
+// Pause at the given position
+func (vp *VideoPlayer) Pause(currentTime float32) (*PlayerState, error) {
+	return vp.update(func(state *PlayerState) {
+		state.Playing = false
+		state.Position = float64(currentTime)
+		state.UpdatedAt = time.Now()
+		state.AutoPaused = false
+	})
 }
 
-func (vp *VideoPlayer) Pause() {
-	vp.t.pause()
+// Pause at the current position of the clock, autoPaused marks a pause that
+// should be resumed when members are ready again
+func (vp *VideoPlayer) PauseNow(autoPaused bool) (*PlayerState, error) {
+	return vp.update(func(state *PlayerState) {
+		now := time.Now()
+		state.Position = state.CurrentTime(now)
+		state.Playing = false
+		state.UpdatedAt = now
+		state.AutoPaused = autoPaused
+	})
+}
+
+// Jump to the given position while preserving playing/paused state
+func (vp *VideoPlayer) Seek(currentTime float32) (*PlayerState, error) {
+	return vp.update(func(state *PlayerState) {
+		state.Position = float64(currentTime)
+		state.UpdatedAt = time.Now()
+	})
+}
+
+// Change playback rate, position is rebased so the clock stays continuous
+func (vp *VideoPlayer) SetRate(rate float32) (*PlayerState, error) {
+	if err := ValidatePlaybackRate(rate); err != nil {
+		return nil, err
+	}
+	return vp.update(func(state *PlayerState) {
+		now := time.Now()
+		state.Position = state.CurrentTime(now)
+		state.UpdatedAt = now
+		state.Rate = float64(rate)
+	})
+}
+
+// Check if rate is a finite playback rate within bounds
+func ValidatePlaybackRate(rate float32) error {
+	if math.IsNaN(float64(rate)) || rate < minPlaybackRate || rate > maxPlaybackRate {
+		return fmt.Errorf("invalid playback rate: %v", rate)
+	}
+	return nil
+}
+
+// Reset the clock for a media source, used when media source changes
+func (vp *VideoPlayer) End(mediaSourceId string) error {
+	if err := redis.Client.Del(context.Background(), vp.bufferingKey).Err(); err != nil {
+		return err
+	}
+	return vp.save(&PlayerState{Rate: 1, UpdatedAt: time.Now(), MediaSourceId: mediaSourceId})
+}
+
+// Reset the clock unless it was already reset for the media source, every
+// instance loads the same media source change but the clock is reset once.
+// Returns true if the clock was reset
+func (vp *VideoPlayer) Load(mediaSourceId string) (bool, error) {
+	loaded := false
+	_, err := vp.update(func(state *PlayerState) {
+		loaded = state.MediaSourceId != mediaSourceId
+		if loaded {
+			*state = PlayerState{Rate: 1, UpdatedAt: time.Now(), MediaSourceId: mediaSourceId}
+		}
+	})
+	if err != nil || !loaded {
+		return false, err
+	}
+	return true, redis.Client.Del(context.Background(), vp.bufferingKey).Err()
+}
+
+// Mark a client as buffering or ready
+func (vp *VideoPlayer) SetBuffering(clientId string, buffering bool) error {
+	if buffering {
+		return redis.Client.SAdd(context.Background(), vp.bufferingKey, clientId).Err()
+	}
+	return redis.Client.SRem(context.Background(), vp.bufferingKey, clientId).Err()
 }
 
-func (vp *VideoPlayer) End() {
-	vp.t.end()
+// Get number of buffering clients
+func (vp *VideoPlayer) Buffering() int64 {
+	return redis.Client.SCard(context.Background(), vp.bufferingKey).Val()
 }
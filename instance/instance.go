@@ -0,0 +1,279 @@
+package instance
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/castyapp/gateway.server/redis"
+	"github.com/getsentry/sentry-go"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+const (
+	HeartbeatInterval = 5 * time.Second
+	// Instance is dead when it missed heartbeats for this duration
+	aliveTTL = 3 * HeartbeatInterval
+	// Clients of a dead instance are kept this long for the janitor
+	clientsTTL = 24 * time.Hour
+)
+
+var (
+	// ID of this gateway process
+	ID = uuid.New().String()
+
+	startedAt = time.Now()
+	hubs      []Hub
+	// number of clients created by this process, accessed atomically
+	clients uint64
+	// set when this instance was taken for dead while it was alive, clients
+	// are restored once the janitor is done with them. Accessed atomically
+	revived int32
+	// set when this instance is stopped, accessed atomically
+	stopped int32
+)
+
+// Hub of this instance that reports its connections to the registry
+type Hub interface {
+	Name() string
+	Connections() int
+	// Record connected clients again that are missing from owned clients of
+	// this instance, they were cleaned up by the janitor of another instance
+	Restore(ctx context.Context, owned map[string]string)
+}
+
+// Info is what an instance registers about itself on every heartbeat
+type Info struct {
+	Id          string         `json:"id"`
+	Hostname    string         `json:"hostname"`
+	StartedAt   time.Time      `json:"started_at"`
+	Heartbeat   time.Time      `json:"heartbeat"`
+	Connections map[string]int `json:"connections"`
+}
+
+// Get a new client id, client ids are prefixed with id of their instance
+func NextClientId() string {
+	return fmt.Sprintf("%s-%d", ID, atomic.AddUint64(&clients, 1))
+}
+
+// Get id of the instance that owns a client
+func Owner(clientId string) string {
+	if i := strings.LastIndex(clientId, "-"); i > 0 {
+		return clientId[:i]
+	}
+	return ""
+}
+
+func info() *Info {
+	hostname, _ := os.Hostname()
+	info := &Info{
+		Id:          ID,
+		Hostname:    hostname,
+		StartedAt:   startedAt,
+		Heartbeat:   time.Now(),
+		Connections: make(map[string]int),
+	}
+	for _, hub := range hubs {
+		info.Connections[hub.Name()] = hub.Connections()
+	}
+	return info
+}
+
+func aliveKey(id string) string {
+	return redis.Keys.InstanceAlive(id)
+}
+
+func clientsKey(id string) string {
+	return redis.Keys.InstanceClients(id)
+}
+
+// Register this instance as alive, its clients are restored if it was taken
+// for dead. Start sends a heartbeat on every heartbeat interval
+func Heartbeat(ctx context.Context) error {
+	if atomic.LoadInt32(&stopped) == 1 {
+		return nil
+	}
+	data, err := json.Marshal(info())
+	if err != nil {
+		return err
+	}
+	pipe := redis.Client.TxPipeline()
+	alive := pipe.Exists(ctx, aliveKey(ID))
+	pipe.SAdd(ctx, redis.Keys.Instances(), ID)
+	pipe.Set(ctx, aliveKey(ID), data, aliveTTL)
+	pipe.Expire(ctx, clientsKey(ID), clientsTTL)
+	if _, err = pipe.Exec(ctx); err != nil {
+		return err
+	}
+	if alive.Val() == 0 && len(hubs) > 0 && atomic.CompareAndSwapInt32(&revived, 0, 1) {
+		log.Printf("Instance [%s] missed its heartbeats, restoring its clients", ID)
+	}
+	if atomic.LoadInt32(&revived) == 1 {
+		return restore(ctx)
+	}
+	return nil
+}
+
+// Releases the janitor lock only if it is still held by the given instance, so
+// a lock that expired and was taken by another instance is kept.
+// KEYS: janitor lock. ARGV: instance id
+var unlockJanitorScript = goredis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// Restore clients of this instance after it was taken for dead. The janitor
+// lock is held so clients are not restored while they're being cleaned up,
+// restoring is retried on the next heartbeat when janitor is running
+func restore(ctx context.Context) error {
+	locked, err := redis.Client.SetNX(ctx, redis.Keys.JanitorLock(), ID, HeartbeatInterval).Result()
+	if err != nil || !locked {
+		return err
+	}
+	defer unlockJanitorScript.Run(ctx, redis.Client, []string{redis.Keys.JanitorLock()}, ID)
+	owned, err := Clients(ctx, ID)
+	if err != nil {
+		return err
+	}
+	for _, hub := range hubs {
+		hub.Restore(ctx, owned)
+	}
+	atomic.StoreInt32(&revived, 0)
+	return nil
+}
+
+// Register this instance and keep it alive with heartbeats until ctx is done
+func Start(ctx context.Context, instanceHubs ...Hub) error {
+	if err := Heartbeat(ctx); err != nil {
+		return err
+	}
+	hubs = instanceHubs
+	go func() {
+		ticker := time.NewTicker(HeartbeatInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := Heartbeat(ctx); err != nil {
+					log.Println(fmt.Errorf("could not send instance heartbeat: %v", err))
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// Unregister this instance after its clients left. Clients that are still
+// owned are kept for the janitor, which releases them right away since this
+// instance is not alive anymore
+func Stop(ctx context.Context) error {
+	atomic.StoreInt32(&stopped, 1)
+	owned, err := redis.Client.HLen(ctx, clientsKey(ID)).Result()
+	if err != nil {
+		return err
+	}
+	if owned > 0 {
+		return redis.Client.Del(ctx, aliveKey(ID)).Err()
+	}
+	return Forget(ctx, ID)
+}
+
+// Record a client that is owned by this instance, data is what the janitor
+// needs to clean up after the client if this instance dies
+func Own(ctx context.Context, clientId string, data []byte) {
+	pipe := redis.Client.TxPipeline()
+	pipe.HSet(ctx, clientsKey(ID), clientId, data)
+	pipe.Expire(ctx, clientsKey(ID), clientsTTL)
+	if _, err := pipe.Exec(ctx); err != nil {
+		sentry.CaptureException(fmt.Errorf("could not record client ownership: %v", err))
+	}
+}
+
+// Remove a client of this instance
+func Disown(ctx context.Context, clientId string) {
+	if err := redis.Client.HDel(ctx, clientsKey(ID), clientId).Err(); err != nil {
+		sentry.CaptureException(fmt.Errorf("could not remove client ownership: %v", err))
+	}
+}
+
+// Get ids of registered instances that stopped sending heartbeats
+func Dead(ctx context.Context) ([]string, error) {
+	ids, err := redis.Client.SMembers(ctx, redis.Keys.Instances()).Result()
+	if err != nil {
+		return nil, err
+	}
+	var dead []string
+	for _, id := range ids {
+		if id == ID {
+			continue
+		}
+		alive, err := IsMember(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if !alive {
+			dead = append(dead, id)
+		}
+	}
+	return dead, nil
+}
+
+// Get registered instances that are alive
+func Members(ctx context.Context) ([]*Info, error) {
+	ids, err := redis.Client.SMembers(ctx, redis.Keys.Instances()).Result()
+	if err != nil {
+		return nil, err
+	}
+	members := make([]*Info, 0, len(ids))
+	for _, id := range ids {
+		data, err := redis.Client.Get(ctx, aliveKey(id)).Bytes()
+		if err == goredis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		member := new(Info)
+		if err := json.Unmarshal(data, member); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+// Check if an instance is alive
+func IsMember(ctx context.Context, id string) (bool, error) {
+	alive, err := redis.Client.Exists(ctx, aliveKey(id)).Result()
+	return alive == 1, err
+}
+
+// Get clients of an instance by client id
+func Clients(ctx context.Context, id string) (map[string]string, error) {
+	return redis.Client.HGetAll(ctx, clientsKey(id)).Result()
+}
+
+// Remove a client of a dead instance, returns false if it was removed already
+func Release(ctx context.Context, id, clientId string) (bool, error) {
+	removed, err := redis.Client.HDel(ctx, clientsKey(id), clientId).Result()
+	return removed == 1, err
+}
+
+// Remove an instance and its clients from redis
+func Forget(ctx context.Context, id string) error {
+	pipe := redis.Client.TxPipeline()
+	pipe.Del(ctx, aliveKey(id), clientsKey(id))
+	pipe.SRem(ctx, redis.Keys.Instances(), id)
+	_, err := pipe.Exec(ctx)
+	return err
+}
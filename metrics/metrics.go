@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "casty_gateway"
+
+var (
+	// ConnectedClients is the number of websocket clients connected to each hub
+	ConnectedClients = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "connected_clients",
+		Help:      "Number of connected websocket clients.",
+	}, []string{"hub"})
+
+	// ActiveRooms is the number of rooms that have clients on this instance
+	ActiveRooms = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_rooms",
+		Help:      "Number of rooms with clients on this instance.",
+	}, []string{"hub"})
+
+	// LogOns counts logon attempts by result: success, guest or failure
+	LogOns = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logons_total",
+		Help:      "Number of logon attempts by result.",
+	}, []string{"hub", "result"})
+
+	// EventsReceived counts events received from clients by EMSG
+	EventsReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "events_received_total",
+		Help:      "Number of events received from clients by EMSG.",
+	}, []string{"hub", "emsg"})
+
+	// PendingWrites is the number of messages that are being written or
+	// waiting for another write to the same client connection
+	PendingWrites = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "pending_writes",
+		Help:      "Number of messages being written or waiting to be written to clients.",
+	}, []string{"hub"})
+
+	// Disconnects counts client disconnects by reason
+	Disconnects = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "disconnects_total",
+		Help:      "Number of client disconnects by reason.",
+	}, []string{"hub", "reason"})
+
+	// RedisPublishDuration observes latency of redis publishes
+	RedisPublishDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "redis_publish_duration_seconds",
+		Help:      "Latency of redis publish commands.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 12),
+	})
+
+	// DependencyUp is 1 when a dependency is available and 0 when it's down
+	DependencyUp = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "dependency_up",
+		Help:      "Whether a dependency of gateway is available.",
+	}, []string{"dependency"})
+
+	// GrpcDuration observes latency of grpc calls by method and status code
+	GrpcDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "grpc_call_duration_seconds",
+		Help:      "Latency of grpc calls by method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "code"})
+)
+
+// Handler serves metrics in prometheus format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+type startKey struct{}
+
+// RedisHook observes latency of redis publish commands
+type RedisHook struct{}
+
+func (RedisHook) BeforeProcess(ctx context.Context, cmd goredis.Cmder) (context.Context, error) {
+	if strings.EqualFold(cmd.Name(), "publish") {
+		return context.WithValue(ctx, startKey{}, time.Now()), nil
+	}
+	return ctx, nil
+}
+
+func (RedisHook) AfterProcess(ctx context.Context, cmd goredis.Cmder) error {
+	if start, ok := ctx.Value(startKey{}).(time.Time); ok {
+		RedisPublishDuration.Observe(time.Since(start).Seconds())
+	}
+	return nil
+}
+
+func (RedisHook) BeforeProcessPipeline(ctx context.Context, cmds []goredis.Cmder) (context.Context, error) {
+	return ctx, nil
+}
+
+func (RedisHook) AfterProcessPipeline(ctx context.Context, cmds []goredis.Cmder) error {
+	return nil
+}
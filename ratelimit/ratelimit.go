@@ -0,0 +1,86 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/castyapp/gateway.server/redis"
+	goredis "github.com/go-redis/redis/v8"
+)
+
+// Limit of a token bucket, bucket is refilled by Rate tokens per second and
+// holds up to Burst tokens
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Takes a token from the bucket at KEYS[1]. Redis clock is used so buckets
+// are consistent between gateway instances. ARGV: rate, burst.
+// Returns allowed and seconds to wait for the next token
+var takeTokenScript = goredis.NewScript(`
+redis.replicate_commands()
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) + tonumber(time[2]) / 1000000
+local bucket = redis.call("HMGET", KEYS[1], "tokens", "updated_at")
+local tokens = tonumber(bucket[1]) or burst
+local updated = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) * rate)
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = (1 - tokens) / rate
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "updated_at", tostring(now))
+redis.call("EXPIRE", KEYS[1], math.ceil(burst / rate) + 1)
+return {allowed, tostring(retry)}
+`)
+
+// Take a token from the bucket at key, a rate limit key of redis.Keys.
+// Returns false and the time to wait for the next token when bucket is empty
+func Allow(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+
+	if limit.Rate <= 0 || limit.Burst <= 0 {
+		return true, 0, nil
+	}
+
+	reply, err := takeTokenScript.Run(ctx, redis.Client, []string{key}, limit.Rate, limit.Burst).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	result, ok := reply.([]interface{})
+	if !ok || len(result) != 2 {
+		return false, 0, fmt.Errorf("unexpected rate limit result: %v", result)
+	}
+
+	allowed, _ := result[0].(int64)
+	retry, _ := result[1].(string)
+	seconds, _ := strconv.ParseFloat(retry, 64)
+
+	return allowed == 1, time.Duration(seconds * float64(time.Second)), nil
+}
+
+// Puts a token back to the bucket at KEYS[1], up to burst tokens. ARGV: burst
+var refundTokenScript = goredis.NewScript(`
+local tokens = tonumber(redis.call("HGET", KEYS[1], "tokens"))
+if tokens then
+	redis.call("HSET", KEYS[1], "tokens", tostring(math.min(tonumber(ARGV[1]), tokens + 1)))
+end
+return 0
+`)
+
+// Give back a token taken from the bucket at key, used when an action is
+// denied by another bucket after this one allowed it
+func Refund(ctx context.Context, key string, limit Limit) error {
+	if limit.Rate <= 0 || limit.Burst <= 0 {
+		return nil
+	}
+	return refundTokenScript.Run(ctx, redis.Client, []string{key}, limit.Burst).Err()
+}
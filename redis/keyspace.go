@@ -0,0 +1,218 @@
+package redis
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Keys of this gateway, configured by redis prefix
+var Keys = NewKeyspace("")
+
+// Keyspace builds every redis key and channel of gateway under a prefix, so
+// several environments or tenants can share one redis. Backend services that
+// publish on theater and user channels should use the same prefix.
+//
+// Keys of a theater are hash tagged by theater id so they share a slot in
+// redis cluster and can be used together in scripts and transactions
+type Keyspace struct {
+	prefix string
+}
+
+// Create a keyspace, an empty prefix keeps keys unprefixed
+func NewKeyspace(prefix string) *Keyspace {
+	return &Keyspace{prefix: strings.TrimSuffix(prefix, ":")}
+}
+
+// Check if prefix can be used in redis keys
+func ValidatePrefix(prefix string) error {
+	if strings.ContainsAny(prefix, "{} \t\r\n*?[]") {
+		return fmt.Errorf("invalid redis prefix: %q", prefix)
+	}
+	return nil
+}
+
+func (k *Keyspace) Prefix() string {
+	return k.prefix
+}
+
+func (k *Keyspace) key(format string, args ...interface{}) string {
+	key := fmt.Sprintf(format, args...)
+	if k.prefix == "" {
+		return key
+	}
+	return k.prefix + ":" + key
+}
+
+func (k *Keyspace) theater(kind, theaterId string) string {
+	return k.key("theater:%s:{%s}", kind, theaterId)
+}
+
+// Channel of events that are sent to all clients of theater
+func (k *Keyspace) TheaterEvents(theaterId string) string {
+	return k.key("theater:events:%s", theaterId)
+}
+
+// Channel of events that are handled by theater rooms of all instances
+func (k *Keyspace) TheaterRoom(theaterId string) string {
+	return k.key("theater:room:%s", theaterId)
+}
+
+func (k *Keyspace) TheaterMembers(theaterId string) string {
+	return k.theater("members", theaterId)
+}
+
+func (k *Keyspace) TheaterJoined(theaterId string) string {
+	return k.theater("members:joined", theaterId)
+}
+
+func (k *Keyspace) TheaterHost(theaterId string) string {
+	return k.theater("host", theaterId)
+}
+
+func (k *Keyspace) TheaterCoHost(theaterId string) string {
+	return k.theater("cohost", theaterId)
+}
+
+func (k *Keyspace) TheaterClients(theaterId string) string {
+	return k.theater("clients", theaterId)
+}
+
+func (k *Keyspace) TheaterWaiting(theaterId string) string {
+	return k.theater("waiting", theaterId)
+}
+
+func (k *Keyspace) TheaterPlayer(theaterId string) string {
+	return k.theater("player", theaterId)
+}
+
+func (k *Keyspace) TheaterBuffering(theaterId string) string {
+	return k.theater("buffering", theaterId)
+}
+
+func (k *Keyspace) TheaterQueue(theaterId string) string {
+	return k.theater("queue", theaterId)
+}
+
+func (k *Keyspace) TheaterQueueItems(theaterId string) string {
+	return k.theater("queue:items", theaterId)
+}
+
+func (k *Keyspace) TheaterQueueCurrent(theaterId string) string {
+	return k.theater("queue:current", theaterId)
+}
+
+func (k *Keyspace) TheaterQueueAdvance(theaterId string) string {
+	return k.theater("queue:advance", theaterId)
+}
+
+func (k *Keyspace) TheaterDemocratic(theaterId string) string {
+	return k.theater("democratic", theaterId)
+}
+
+func (k *Keyspace) TheaterVote(theaterId, action string) string {
+	return k.key("theater:vote:{%s}:%s", theaterId, action)
+}
+
+func (k *Keyspace) TheaterAccess(theaterId string) string {
+	return k.theater("access", theaterId)
+}
+
+func (k *Keyspace) TheaterSchedule(theaterId string) string {
+	return k.theater("schedule", theaterId)
+}
+
+// Claimed by the instance that starts a theater scheduled at the given unix
+// milliseconds
+func (k *Keyspace) TheaterScheduleLeader(theaterId string, ms int64) string {
+	return k.key("theater:schedule:{%s}:%d", theaterId, ms)
+}
+
+// Users that accepted an invite to theater, scored by expiry
+func (k *Keyspace) TheaterInvited(theaterId string) string {
+	return k.theater("invited", theaterId)
+}
+
+func (k *Keyspace) TheaterInvite(inviteId string) string {
+	return k.key("theater:invite:%s", inviteId)
+}
+
+// Number of clients that are seated in all theaters by instance
+func (k *Keyspace) TheaterSeats() string {
+	return k.key("theater:seats:instances")
+}
+
+// Theaters that have clients waiting for a seat
+func (k *Keyspace) TheatersWaiting() string {
+	return k.key("theaters:waiting")
+}
+
+// Channel of events that are sent to all clients of user
+func (k *Keyspace) UserEvents(userId string) string {
+	return k.key("user:events:%s", userId)
+}
+
+func (k *Keyspace) UserClients(userId string) string {
+	return k.key("user:clients:%s", userId)
+}
+
+// Rate limit buckets of an event of a guest client, an authenticated user
+// across its clients and a theater
+func (k *Keyspace) ClientRateLimit(clientId, event string) string {
+	return k.key("ratelimit:client:%s:%s", clientId, event)
+}
+
+func (k *Keyspace) UserRateLimit(userId, event string) string {
+	return k.key("ratelimit:user:%s:%s", userId, event)
+}
+
+func (k *Keyspace) TheaterRateLimit(theaterId, event string) string {
+	return k.key("ratelimit:theater:%s:%s", theaterId, event)
+}
+
+func (k *Keyspace) Cache(name, key string) string {
+	return k.key("cache:%s:%s", name, key)
+}
+
+func (k *Keyspace) CacheTag(name, tag string) string {
+	return k.key("cache:%s:tags:%s", name, tag)
+}
+
+// Channel of cache invalidations
+func (k *Keyspace) CacheInvalidation() string {
+	return k.key("cache:invalidate")
+}
+
+// Channel of system events
+func (k *Keyspace) SystemEvents() string {
+	return k.key("system:events")
+}
+
+// Channel of admin requests of all instances
+func (k *Keyspace) AdminRequests() string {
+	return k.key("admin:requests")
+}
+
+// Channel of admin requests of one instance
+func (k *Keyspace) AdminInstanceRequests(instanceId string) string {
+	return k.key("admin:requests:%s", instanceId)
+}
+
+func (k *Keyspace) AdminReplies(requestId string) string {
+	return k.key("admin:replies:%s", requestId)
+}
+
+func (k *Keyspace) Instances() string {
+	return k.key("gateway:instances")
+}
+
+func (k *Keyspace) InstanceAlive(instanceId string) string {
+	return k.key("gateway:instance:{%s}:alive", instanceId)
+}
+
+func (k *Keyspace) InstanceClients(instanceId string) string {
+	return k.key("gateway:instance:{%s}:clients", instanceId)
+}
+
+func (k *Keyspace) JanitorLock() string {
+	return k.key("gateway:janitor")
+}
@@ -0,0 +1,155 @@
+package redis
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// Messages are dropped for a subscription that is this many messages behind
+const subscriptionBuffer = 256
+
+// Subscription of a local listener to a redis channel, messages are handled
+// in order on a goroutine of the subscription so a slow listener does not
+// hold up others
+type Subscription struct {
+	channel  string
+	handler  func(payload string)
+	messages chan string
+	done     chan struct{}
+	once     sync.Once
+}
+
+func (s *Subscription) run() {
+	for {
+		select {
+		case <-s.done:
+			return
+		case payload := <-s.messages:
+			s.handler(payload)
+		}
+	}
+}
+
+func (s *Subscription) deliver(payload string) {
+	select {
+	case s.messages <- payload:
+	default:
+		log.Printf("Subscription of channel [%s] is full, message dropped", s.channel)
+	}
+}
+
+// Stop receiving messages, the channel is unsubscribed when it was the last
+// local listener of it
+func (s *Subscription) Unsubscribe() {
+	s.once.Do(func() {
+		close(s.done)
+		mux.remove(s)
+	})
+}
+
+// multiplexer shares one redis PubSub between all listeners of a process,
+// channels are subscribed when their first local listener appears and
+// unsubscribed when the last one is gone
+type multiplexer struct {
+	mu       sync.Mutex
+	pubsub   *redis.PubSub
+	channels map[string]map[*Subscription]struct{}
+}
+
+var mux = &multiplexer{
+	channels: make(map[string]map[*Subscription]struct{}),
+}
+
+func (m *multiplexer) add(s *Subscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	subscriptions, ok := m.channels[s.channel]
+	if !ok {
+		if m.pubsub == nil {
+			// PubSub is created with its first channel
+			m.pubsub = Client.Subscribe(context.Background(), s.channel)
+			go m.dispatch(m.pubsub)
+		} else if err := m.pubsub.Subscribe(context.Background(), s.channel); err != nil {
+			return err
+		}
+		subscriptions = make(map[*Subscription]struct{})
+		m.channels[s.channel] = subscriptions
+	}
+	subscriptions[s] = struct{}{}
+	return nil
+}
+
+func (m *multiplexer) remove(s *Subscription) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	subscriptions, ok := m.channels[s.channel]
+	if !ok {
+		return
+	}
+	delete(subscriptions, s)
+	if len(subscriptions) > 0 {
+		return
+	}
+	delete(m.channels, s.channel)
+	if m.pubsub != nil {
+		if err := m.pubsub.Unsubscribe(context.Background(), s.channel); err != nil {
+			log.Printf("Could not unsubscribe channel [%s]: %v", s.channel, err)
+		}
+	}
+}
+
+func (m *multiplexer) dispatch(pubsub *redis.PubSub) {
+	for msg := range pubsub.Channel() {
+		m.mu.Lock()
+		for s := range m.channels[msg.Channel] {
+			s.deliver(msg.Payload)
+		}
+		m.mu.Unlock()
+	}
+}
+
+func (m *multiplexer) close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.pubsub == nil {
+		return nil
+	}
+	err := m.pubsub.Close()
+	m.pubsub = nil
+	m.channels = make(map[string]map[*Subscription]struct{})
+	return err
+}
+
+// Subscribe a local listener to a redis channel over the shared PubSub of
+// this process
+func Subscribe(channel string, handler func(payload string)) (*Subscription, error) {
+	s := &Subscription{
+		channel:  channel,
+		handler:  handler,
+		messages: make(chan string, subscriptionBuffer),
+		done:     make(chan struct{}),
+	}
+	if err := mux.add(s); err != nil {
+		return nil, err
+	}
+	go s.run()
+	return s, nil
+}
+
+// Subscribe a local listener until ctx is done
+func SubscribeContext(ctx context.Context, channel string, handler func(payload string)) error {
+	s, err := Subscribe(channel, handler)
+	if err != nil {
+		return err
+	}
+	go func() {
+		<-ctx.Done()
+		s.Unsubscribe()
+	}()
+	return nil
+}
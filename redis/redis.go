@@ -2,47 +2,93 @@ package redis
 
 import (
 	"context"
+	"crypto/tls"
+	"fmt"
 	"log"
 
 	"github.com/castyapp/gateway.server/config"
+	"github.com/castyapp/gateway.server/metrics"
 	"github.com/go-redis/redis/v8"
 )
 
-var (
-	Client *redis.Client
+// Modes of connecting to redis
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
 )
 
-func Configure() error {
+var (
+	Client redis.UniversalClient
+)
 
+// Get configured redis mode, cluster = true of older configs meant sentinel
+func Mode() string {
+	if mode := config.Map.Redis.Mode; mode != "" {
+		return mode
+	}
 	if config.Map.Redis.Cluster {
-		Client = redis.NewFailoverClient(&redis.FailoverOptions{
-			SentinelAddrs:    config.Map.Redis.Sentinels,
-			Password:         config.Map.Redis.Pass,
-			SentinelPassword: config.Map.Redis.SentinelPass,
-			MasterName:       config.Map.Redis.MasterName,
-			DB:               0,
-		})
-	} else {
-		Client = redis.NewClient(&redis.Options{
-			Addr:     config.Map.Redis.Addr,
-			Password: config.Map.Redis.Pass,
-		})
+		return ModeSentinel
 	}
+	return ModeStandalone
+}
 
-	cmd := Client.Ping(context.Background())
-	if res := cmd.Val(); res != "PONG" {
-
-		if config.Map.Redis.Cluster {
-			log.Println("SentinelAddrs: ", config.Map.Redis.Sentinels)
-		} else {
-			log.Println("Addr: ", config.Map.Redis.Addr)
+func options() *redis.UniversalOptions {
+	c := config.Map.Redis
+	opts := &redis.UniversalOptions{
+		Username:         c.Username,
+		Password:         c.Pass,
+		SentinelPassword: c.SentinelPass,
+		MasterName:       c.MasterName,
+	}
+	if c.TLS {
+		opts.TLSConfig = &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: c.TLSInsecureSkipVerify,
 		}
+	}
+	return opts
+}
 
-		log.Fatalf("Could not ping the redis server: %v", cmd.Err())
+func Configure() error {
+
+	if err := ValidatePrefix(config.Map.Redis.Prefix); err != nil {
+		return err
+	}
+	Keys = NewKeyspace(config.Map.Redis.Prefix)
+
+	opts := options()
+	mode := Mode()
+
+	switch mode {
+	case ModeSentinel:
+		opts.Addrs = config.Map.Redis.Sentinels
+		Client = redis.NewFailoverClient(opts.Failover())
+	case ModeCluster:
+		opts.Addrs = config.Map.Redis.Addrs
+		Client = redis.NewClusterClient(opts.Cluster())
+	case ModeStandalone:
+		opts.Addrs = []string{config.Map.Redis.Addr}
+		Client = redis.NewClient(opts.Simple())
+	default:
+		return fmt.Errorf("invalid redis mode: %s", mode)
 	}
+
+	Client.AddHook(metrics.RedisHook{})
+
+	// connection is checked by Ping
+	log.Printf("Redis Mode: %s, Addrs: %v, Prefix: %q", mode, opts.Addrs, Keys.Prefix())
 	return nil
 }
 
+// Check if redis server is reachable
+func Ping(ctx context.Context) error {
+	return Client.Ping(ctx).Err()
+}
+
 func Close() error {
+	if err := mux.close(); err != nil {
+		log.Printf("Could not close redis pubsub: %v", err)
+	}
 	return Client.Close()
 }
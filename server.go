@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
@@ -11,9 +12,13 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/castyapp/gateway.server/cache"
 	"github.com/castyapp/gateway.server/config"
 	"github.com/castyapp/gateway.server/grpc"
+	"github.com/castyapp/gateway.server/health"
 	"github.com/castyapp/gateway.server/hub"
+	"github.com/castyapp/gateway.server/instance"
+	"github.com/castyapp/gateway.server/metrics"
 	"github.com/castyapp/gateway.server/redis"
 	"github.com/getsentry/sentry-go"
 	"github.com/gorilla/mux"
@@ -21,9 +26,11 @@ import (
 
 var (
 	userGatewayPort,
-	theaterGatewayPort *int
+	theaterGatewayPort,
+	adminPort *int
 	userGatewayHost,
-	theaterGatewayHost *string
+	theaterGatewayHost,
+	adminHost *string
 	env *string
 )
 
@@ -36,6 +43,9 @@ func init() {
 	theaterGatewayPort = flag.Int("tg-port", 3002, "TheaterGateway server port")
 	theaterGatewayHost = flag.String("tg-host", "0.0.0.0", "TheaterGateway server host")
 
+	adminPort = flag.Int("admin-port", 3003, "Admin server port, serves metrics")
+	adminHost = flag.String("admin-host", "127.0.0.1", "Admin server host")
+
 	env = flag.String("env", "development", "Environment")
 	configFileName := flag.String("config-file", "config.hcl", "config.hcl file")
 
@@ -45,6 +55,10 @@ func init() {
 	if err := config.LoadFile(*configFileName); err != nil {
 		log.Fatal(fmt.Errorf("could not load config: %v", err))
 	}
+}
+
+// Configure dependencies of gateway and wait for them to be available
+func setup() {
 
 	if err := grpc.Configure(); err != nil {
 		log.Fatal(fmt.Errorf("could not configure grpc.server: %v", err))
@@ -54,6 +68,16 @@ func init() {
 		log.Fatal(fmt.Errorf("could not configure redis: %v", err))
 	}
 
+	health.Register("redis", redis.Ping)
+	health.Register("grpc", grpc.Ping)
+	if err := health.WaitForAll(context.Background()); err != nil {
+		log.Fatal(fmt.Errorf("could not start gateway: %v", err))
+	}
+
+	if err := cache.Configure(); err != nil {
+		log.Fatal(fmt.Errorf("could not configure cache: %v", err))
+	}
+
 	if config.Map.Sentry.Enabled {
 		if err := sentry.Init(sentry.ClientOptions{Dsn: config.Map.Sentry.Dsn}); err != nil {
 			log.Fatal(fmt.Errorf("could not initilize sentry: %v", err))
@@ -63,6 +87,20 @@ func init() {
 
 func main() {
 
+	// subcommands of server, they only need redis
+	if flag.Arg(0) == "announce" {
+		if err := redis.Configure(); err != nil {
+			log.Fatal(fmt.Errorf("could not configure redis: %v", err))
+		}
+		defer redis.Close()
+		if err := announce(flag.Args()[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	setup()
+
 	userGatewayListener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", *userGatewayHost, *userGatewayPort))
 	if err != nil {
 		sentry.CaptureException(err)
@@ -77,6 +115,13 @@ func main() {
 		return
 	}
 
+	adminListener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", *adminHost, *adminPort))
+	if err != nil {
+		sentry.CaptureException(err)
+		log.Fatal(err)
+		return
+	}
+
 	var (
 		usersHub    = hub.NewUserHub()
 		theatersHub = hub.NewTheaterHub()
@@ -87,6 +132,13 @@ func main() {
 	go func() {
 		<-sig
 		fmt.Printf("Got interrupt Signal. Cleaning up...\n")
+		// Report not ready while load balancers catch up
+		usersHub.Drain()
+		theatersHub.Drain()
+		if drain := config.Map.Health.DrainTimeout; drain > 0 {
+			log.Printf("Draining for %d seconds", drain)
+			time.Sleep(time.Duration(drain) * time.Second)
+		}
 		// Close usersHub
 		if err := usersHub.Close(); err != nil {
 			mErr := fmt.Errorf("could not close UserHub: %v", err)
@@ -99,6 +151,12 @@ func main() {
 			sentry.CaptureException(mErr)
 			log.Println(mErr)
 		}
+		// Clients left their rooms, the janitor releases what is left
+		if err := instance.Stop(context.Background()); err != nil {
+			mErr := fmt.Errorf("could not unregister instance: %v", err)
+			sentry.CaptureException(mErr)
+			log.Println(mErr)
+		}
 		os.Exit(1)
 	}()
 
@@ -117,6 +175,12 @@ func main() {
 			log.Println(mErr)
 		}
 
+		if err := adminListener.Close(); err != nil {
+			mErr := fmt.Errorf("could not close TCPListener: %v", err)
+			sentry.CaptureException(mErr)
+			log.Println(mErr)
+		}
+
 		// Since sentry emits events in the background we need to make sure
 		// they are sent before we shut down
 		if ok := sentry.Flush(time.Second * 5); !ok {
@@ -124,6 +188,13 @@ func main() {
 			log.Println("could not Flush sentry")
 		}
 
+		// Close cache invalidation subscription
+		if err := cache.Close(); err != nil {
+			mErr := fmt.Errorf("could not close Cache: %v", err)
+			sentry.CaptureException(mErr)
+			log.Println(mErr)
+		}
+
 		// Close redis
 		if err := redis.Close(); err != nil {
 			mErr := fmt.Errorf("could not close Redis: %v", err)
@@ -133,8 +204,29 @@ func main() {
 
 	}()
 
+	admin := hub.NewAdmin(context.Background(), usersHub, theatersHub)
+	hub.ListenSystemEvents(context.Background(), usersHub, theatersHub)
+	health.Monitor(context.Background())
+
+	// Register this instance and clean up after crashed ones
+	if err := instance.Start(context.Background(), usersHub, theatersHub); err != nil {
+		sentry.CaptureException(err)
+		log.Fatal(fmt.Errorf("could not register instance: %v", err))
+	}
+	hub.StartJanitor(context.Background(), usersHub, theatersHub)
+
+	adminRouter := mux.NewRouter()
+	adminRouter.Handle("/metrics", metrics.Handler())
+	admin.Register(adminRouter)
+	log.Printf("[Admin] %s server running and listeting on http://%s:%d", *env, *adminHost, *adminPort)
+	go func() {
+		log.Printf("http_err: %v", http.Serve(adminListener, adminRouter))
+	}()
+
 	userGatewayRouter := mux.NewRouter()
 	userGatewayRouter.HandleFunc("/", usersHub.ServeHTTP)
+	userGatewayRouter.HandleFunc("/healthz", health.LivenessHandler(usersHub))
+	userGatewayRouter.HandleFunc("/readyz", health.ReadinessHandler(usersHub))
 	log.Printf("[UserGateway] %s server running and listeting on http://%s:%d", *env, *userGatewayHost, *userGatewayPort)
 	go func() {
 		log.Printf("http_err: %v", http.Serve(userGatewayListener, userGatewayRouter))
@@ -142,6 +234,8 @@ func main() {
 
 	theaterGatewayRouter := mux.NewRouter()
 	theaterGatewayRouter.HandleFunc("/", theatersHub.ServeHTTP)
+	theaterGatewayRouter.HandleFunc("/healthz", health.LivenessHandler(theatersHub))
+	theaterGatewayRouter.HandleFunc("/readyz", health.ReadinessHandler(theatersHub))
 	log.Printf("[TheaterGateway] %s server running and listeting on http://%s:%d", *env, *theaterGatewayHost, *theaterGatewayPort)
 	log.Printf("http_err: %v", http.Serve(theaterGatewayListener, theaterGatewayRouter))
 }
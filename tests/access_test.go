@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/castyapp/gateway.server/hub"
+)
+
+func TestTheaterPassword(t *testing.T) {
+	hash, err := hub.HashTheaterPassword("secret")
+	if err != nil {
+		t.Fatalf("could not hash password: %v", err)
+	}
+	if !hub.CheckTheaterPassword(hash, "secret") {
+		t.Fatalf("expected password to match its hash")
+	}
+	if hub.CheckTheaterPassword(hash, "wrong") {
+		t.Fatalf("expected a wrong password to be rejected")
+	}
+	if hub.CheckTheaterPassword(hash, "") {
+		t.Fatalf("expected an empty password to be rejected")
+	}
+}
+
+func TestCheckTheaterCredentials(t *testing.T) {
+	hash, err := hub.HashTheaterPassword("secret")
+	if err != nil {
+		t.Fatalf("could not hash password: %v", err)
+	}
+
+	tests := []struct {
+		access        hub.TheaterAccess
+		authenticated bool
+		credentials   hub.Credentials
+		reason        string
+	}{
+		{hub.TheaterAccess{Mode: hub.AccessPublic}, false, hub.Credentials{}, ""},
+		{hub.TheaterAccess{Mode: hub.AccessPassword, PasswordHash: hash}, false, hub.Credentials{Password: "secret"}, hub.DeniedUnauthenticated},
+		{hub.TheaterAccess{Mode: hub.AccessPassword, PasswordHash: hash}, true, hub.Credentials{Password: "secret"}, ""},
+		{hub.TheaterAccess{Mode: hub.AccessPassword, PasswordHash: hash}, true, hub.Credentials{Password: "wrong"}, hub.DeniedInvalidPassword},
+		{hub.TheaterAccess{Mode: hub.AccessInviteCode, InviteCode: "code"}, true, hub.Credentials{InviteCode: "code"}, ""},
+		{hub.TheaterAccess{Mode: hub.AccessInviteCode, InviteCode: "code"}, true, hub.Credentials{InviteCode: "other"}, hub.DeniedInvalidInviteCode},
+		{hub.TheaterAccess{Mode: hub.AccessInviteCode}, true, hub.Credentials{}, hub.DeniedInvalidInviteCode},
+		{hub.TheaterAccess{Mode: hub.AccessInviteCode, InviteCode: "code"}, true, hub.Credentials{Invited: true}, ""},
+		{hub.TheaterAccess{Mode: hub.AccessPassword, PasswordHash: hash}, true, hub.Credentials{Invited: true}, ""},
+		{hub.TheaterAccess{Mode: hub.AccessPassword, PasswordHash: hash}, false, hub.Credentials{Invited: true}, hub.DeniedUnauthenticated},
+		{hub.TheaterAccess{Mode: hub.AccessFriends}, false, hub.Credentials{}, hub.DeniedUnauthenticated},
+		{hub.TheaterAccess{Mode: hub.AccessFriends}, true, hub.Credentials{}, ""},
+		{hub.TheaterAccess{Mode: "unknown"}, true, hub.Credentials{}, hub.DeniedAccessUnavailable},
+	}
+
+	for _, test := range tests {
+		err := test.access.CheckCredentials(test.authenticated, test.credentials)
+		if test.reason == "" {
+			if err != nil {
+				t.Fatalf("expected %s theater to allow, got %v", test.access.Mode, err)
+			}
+			continue
+		}
+		denied, ok := err.(*hub.AccessDeniedError)
+		if !ok || denied.Reason != test.reason {
+			t.Fatalf("expected %s theater to deny with %s, got %v", test.access.Mode, test.reason, err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/castyapp/gateway.server/cache"
+	"github.com/castyapp/gateway.server/config"
+	"github.com/castyapp/gateway.server/redis"
+	"github.com/castyapp/libcasty-protocol-go/proto"
+)
+
+func TestLRUEviction(t *testing.T) {
+	lru := cache.NewLRU(2, time.Minute)
+	lru.Set("a", "1", 1)
+	lru.Set("a", "2", 2)
+	if _, ok := lru.Get("1"); !ok {
+		t.Fatalf("expected key 1 to be cached")
+	}
+	lru.Set("b", "3", 3)
+	if _, ok := lru.Get("2"); ok {
+		t.Fatalf("expected least recently used key 2 to be evicted")
+	}
+	if lru.Len() != 2 {
+		t.Fatalf("bad len: %d", lru.Len())
+	}
+}
+
+func TestLRUExpiration(t *testing.T) {
+	lru := cache.NewLRU(0, 10*time.Millisecond)
+	lru.Set("a", "1", 1)
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := lru.Get("1"); ok {
+		t.Fatalf("expected key 1 to be expired")
+	}
+}
+
+func TestLRURemoveTag(t *testing.T) {
+	lru := cache.NewLRU(0, time.Minute)
+	lru.Set("user", "token-1", 1)
+	lru.Set("user", "token-2", 2)
+	lru.Set("other", "token-3", 3)
+	lru.RemoveTag("user")
+	if lru.Len() != 1 {
+		t.Fatalf("bad len: %d", lru.Len())
+	}
+	if _, ok := lru.Get("token-3"); !ok {
+		t.Fatalf("expected token-3 to be cached")
+	}
+}
+
+func TestLRUSetWithRemainingTTL(t *testing.T) {
+	lru := cache.NewLRU(0, time.Minute)
+	lru.SetWithTTL("a", "1", 1, 10*time.Millisecond)
+	lru.SetWithTTL("a", "2", 2, time.Hour)
+	time.Sleep(20 * time.Millisecond)
+	if _, ok := lru.Get("1"); ok {
+		t.Fatalf("expected key 1 to expire with its remaining ttl")
+	}
+	if _, ok := lru.Get("2"); !ok {
+		t.Fatalf("expected key 2 to be cached")
+	}
+}
+
+func TestCacheInvalidation(t *testing.T) {
+	setupRedis(t)
+
+	previous := config.Map.Cache
+	config.Map.Cache = config.CacheConfig{Enabled: true, Size: 10, TTL: 60}
+	if err := cache.Configure(); err != nil {
+		t.Fatalf("could not configure cache: %v", err)
+	}
+	defer func() {
+		_ = cache.Close()
+		config.Map.Cache = previous
+	}()
+	waitSubscribed(t, redis.Keys.CacheInvalidation())
+
+	ctx := context.Background()
+	cache.Users.Set(ctx, "token", &proto.User{Id: "user"})
+	if !cache.Users.Get(ctx, "token", new(proto.User)) {
+		t.Fatalf("expected user to be cached")
+	}
+
+	// invalidations are published by backend services as "<cache>:<tag>"
+	redis.Client.Publish(ctx, redis.Keys.CacheInvalidation(), "users:user")
+	deadline := time.Now().Add(time.Second)
+	for cache.Users.Get(ctx, "token", new(proto.User)) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected user to be invalidated")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
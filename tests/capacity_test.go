@@ -0,0 +1,136 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/castyapp/gateway.server/config"
+	"github.com/castyapp/gateway.server/hub"
+	"github.com/castyapp/gateway.server/instance"
+	"github.com/castyapp/gateway.server/redis"
+)
+
+func setupCapacity(t *testing.T, maxClients, globalMaxClients int, overrides map[string]int) {
+	setupRedis(t)
+	previous := config.Map.Theater
+	config.Map.Theater.MaxClients = maxClients
+	config.Map.Theater.GlobalMaxClients = globalMaxClients
+	config.Map.Theater.MaxClientsOverrides = overrides
+	t.Cleanup(func() { config.Map.Theater = previous })
+}
+
+func takeSeat(t *testing.T, theaterId, clientId string, expected bool) {
+	seated, err := hub.TakeSeat(context.Background(), theaterId, clientId)
+	if err != nil || seated != expected {
+		t.Fatalf("bad seat of %s in %s: %v, expected %v: %v", clientId, theaterId, seated, expected, err)
+	}
+}
+
+func expectSeats(t *testing.T, instanceId string, expected int) {
+	seats, _ := redis.Client.HGet(context.Background(), redis.Keys.TheaterSeats(), instanceId).Int()
+	if seats != expected {
+		t.Fatalf("bad seats of instance %s: %d, expected %d", instanceId, seats, expected)
+	}
+}
+
+func waitForSeat(t *testing.T, theaterId string, clientIds ...string) {
+	ctx := context.Background()
+	for _, clientId := range clientIds {
+		redis.Client.RPush(ctx, redis.Keys.TheaterWaiting(theaterId), clientId)
+	}
+	redis.Client.SAdd(ctx, redis.Keys.TheatersWaiting(), theaterId)
+}
+
+func TestTakeSeat(t *testing.T) {
+	setupCapacity(t, 2, 0, map[string]int{"big": 0})
+
+	takeSeat(t, "theater", "a-1", true)
+	// a seated client keeps its seat and does not reserve another one
+	takeSeat(t, "theater", "a-1", true)
+	takeSeat(t, "theater", "a-2", true)
+	takeSeat(t, "theater", "a-3", false)
+	expectSeats(t, "a", 2)
+
+	// overrides take precedence, 0 is unlimited
+	for _, clientId := range []string{"a-4", "a-5", "a-6"} {
+		takeSeat(t, "big", clientId, true)
+	}
+	expectSeats(t, "a", 5)
+
+	// nobody takes a free seat before clients that are waiting for it
+	redis.Client.SRem(context.Background(), redis.Keys.TheaterClients("theater"), "a-2")
+	waitForSeat(t, "theater", "a-7")
+	takeSeat(t, "theater", "a-8", false)
+}
+
+func TestTakeGlobalSeat(t *testing.T) {
+	setupCapacity(t, 0, 3, nil)
+
+	takeSeat(t, "first", "a-1", true)
+	takeSeat(t, "second", "b-1", true)
+	takeSeat(t, "second", "b-2", true)
+	takeSeat(t, "third", "a-2", false)
+	expectSeats(t, "a", 1)
+	expectSeats(t, "b", 2)
+}
+
+func TestAdmitNext(t *testing.T) {
+	setupCapacity(t, 1, 0, nil)
+	ctx := context.Background()
+	redis.Client.Set(ctx, redis.Keys.InstanceAlive("alive"), "{}", time.Minute)
+
+	takeSeat(t, "theater", "alive-1", true)
+	waitForSeat(t, "theater", "alive-2", "alive-3")
+
+	// theater is full
+	if admitted := hub.AdmitNext(ctx, "theater"); admitted != "" {
+		t.Fatalf("expected nobody to be admitted to a full theater, got %s", admitted)
+	}
+	expectSeats(t, instance.ID, 0)
+
+	redis.Client.SRem(ctx, redis.Keys.TheaterClients("theater"), "alive-1")
+	redis.Client.HIncrBy(ctx, redis.Keys.TheaterSeats(), "alive", -1)
+	if admitted := hub.AdmitNext(ctx, "theater"); admitted != "alive-2" {
+		t.Fatalf("expected first waiting client to be admitted, got %s", admitted)
+	}
+	if !redis.Client.SIsMember(ctx, redis.Keys.TheaterClients("theater"), "alive-2").Val() {
+		t.Fatalf("expected admitted client to be seated")
+	}
+	// the seat reserved by the admitting instance belongs to the client's instance
+	expectSeats(t, instance.ID, 0)
+	expectSeats(t, "alive", 1)
+
+	waiting := redis.Client.LRange(ctx, redis.Keys.TheaterWaiting("theater"), 0, -1).Val()
+	if len(waiting) != 1 || waiting[0] != "alive-3" {
+		t.Fatalf("bad waiting list: %v", waiting)
+	}
+}
+
+func TestAdmitNextSkipsDeadInstances(t *testing.T) {
+	setupCapacity(t, 1, 0, nil)
+	ctx := context.Background()
+	redis.Client.Set(ctx, redis.Keys.InstanceAlive("alive"), "{}", time.Minute)
+
+	// client of a crashed instance is still waiting before others
+	waitForSeat(t, "theater", "dead-1", "alive-1")
+	if admitted := hub.AdmitNext(ctx, "theater"); admitted != "alive-1" {
+		t.Fatalf("expected client of alive instance to be admitted, got %s", admitted)
+	}
+	clients := redis.Client.SMembers(ctx, redis.Keys.TheaterClients("theater")).Val()
+	if len(clients) != 1 || clients[0] != "alive-1" {
+		t.Fatalf("bad seated clients: %v", clients)
+	}
+	expectSeats(t, "dead", 0)
+	expectSeats(t, instance.ID, 0)
+	expectSeats(t, "alive", 1)
+
+	// theater is forgotten once nobody is waiting
+	redis.Client.SRem(ctx, redis.Keys.TheaterClients("theater"), "alive-1")
+	if admitted := hub.AdmitNext(ctx, "theater"); admitted != "" {
+		t.Fatalf("expected nobody to be admitted, got %s", admitted)
+	}
+	if redis.Client.SIsMember(ctx, redis.Keys.TheatersWaiting(), "theater").Val() {
+		t.Fatalf("expected theater without waiting clients to be forgotten")
+	}
+}
@@ -12,18 +12,66 @@ var defaultConfig = &config.ConfMap{
 	Debug: false,
 	Env:   "dev",
 	Redis: config.RedisConfig{
-		Cluster:    false,
+		Mode:       "sentinel",
 		MasterName: "casty",
 		Addr:       "127.0.0.1:26379",
-		Pass:       "super-secure-password",
+		Addrs: []string{
+			"127.0.0.1:7000",
+			"127.0.0.1:7001",
+			"127.0.0.1:7002",
+		},
+		Username: "casty",
+		Pass:     "super-secure-password",
 		Sentinels: []string{
 			"127.0.0.1:26379",
 		},
-		SentinelPass: "super-secure-sentinels-password",
+		SentinelPass:          "super-secure-sentinels-password",
+		TLS:                   false,
+		TLSInsecureSkipVerify: false,
 	},
 	Grpc: config.GrpcConfig{
-		Host: "localhost",
-		Port: 55283,
+		Host:         "localhost",
+		Port:         55283,
+		ServiceToken: "super-secure-service-token",
+	},
+	Cache: config.CacheConfig{
+		Enabled: true,
+		Size:    10000,
+		TTL:     60,
+		Shared:  false,
+	},
+	Theater: config.TheaterConfig{
+		ReadyQuorum:            1.0,
+		TimeSyncInterval:       30,
+		DriftThreshold:         2.0,
+		DriftBroadcastInterval: 5,
+		VoteThreshold:          0.5,
+		VoteTimeout:            30,
+		ReactionsPerSecond:     5,
+		ReactionsBatchMembers:  50,
+		MaxClients:             0,
+		GlobalMaxClients:       0,
+		MaxClientsOverrides: map[string]int{
+			"theater-id": 100,
+		},
+		PasswordHashes: map[string]string{
+			"theater-id": "password-hash",
+		},
+	},
+	RateLimit: config.RateLimitConfig{
+		Enabled: true,
+		Events: map[string]config.EventLimitConfig{
+			"play": {Rate: 1, Burst: 5, RoomRate: 2, RoomBurst: 10},
+			"chat": {Rate: 2, Burst: 10, RoomRate: 20, RoomBurst: 50},
+		},
+	},
+	Admin: config.AdminConfig{
+		Token: "super-secure-admin-token",
+	},
+	Health: config.HealthConfig{
+		CheckInterval:  5,
+		StartupTimeout: 60,
+		DrainTimeout:   10,
 	},
 	Sentry: config.SentryConfig{
 		Enabled: false,
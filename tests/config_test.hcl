@@ -11,21 +11,121 @@ env = "dev"
 grpc {
   host = "localhost"
   port = 55283
+  # Token of gateway, users of crashed gateway instances are set offline on
+  # their behalf. Leave empty to skip it
+  service_token = "super-secure-service-token"
 }
 
 # Redis configurations
 redis {
-  # if you wish to use redis cluster, set this value to true
-  # If cluster is true, sentinels is required
-  # If cluster is false, addr is required
-  cluster     = false
+  # standalone, sentinel or cluster
+  # If mode is standalone, addr is required
+  # If mode is sentinel, master_name and sentinels are required
+  # If mode is cluster, addrs of cluster nodes are required
+  mode        = "sentinel"
   master_name = "casty"
   addr        = "127.0.0.1:26379"
+  addrs       = [
+    "127.0.0.1:7000",
+    "127.0.0.1:7001",
+    "127.0.0.1:7002"
+  ]
   sentinels   = [
     "127.0.0.1:26379"
   ]
+  # ACL username, leave empty to authenticate with pass only
+  username = "casty"
   pass = "super-secure-password"
   sentinel_pass = "super-secure-sentinels-password"
+  tls = false
+  tls_insecure_skip_verify = false
+  # Prefix of every key and channel, lets several environments share one redis.
+  # Backend services that publish theater and user events should use the same prefix
+  prefix = ""
+}
+
+# Cache theaters and authenticated users that are fetched from grpc
+cache {
+  enabled = true
+  # Maximum entries kept in memory for each cache
+  size    = 10000
+  # Time to live in seconds
+  ttl     = 60
+  # Also store entries in redis so other gateway instances can share them
+  shared  = false
+}
+
+# Theater gateway configurations
+theater {
+  # Fraction of theater clients that should not be buffering to keep playing,
+  # video player is paused automatically when it's not reached
+  ready_quorum = 1.0
+  # Interval in seconds of probing clients to estimate their round trip time
+  time_sync_interval = 30
+  # Clients drifted more than this many seconds from theater clock are resynced
+  drift_threshold = 2.0
+  # Interval in seconds of broadcasting theater position while playing
+  drift_broadcast_interval = 5
+  # Fraction of theater members required to pass a vote in democratic mode
+  vote_threshold = 0.5
+  # Seconds a vote stays open
+  vote_timeout = 30
+  # Maximum reactions each client can send per second
+  reactions_per_second = 5
+  # Reactions are aggregated into per second batches in rooms with this many members
+  reactions_batch_members = 50
+  # Maximum clients of each theater, others wait in a waiting list. 0 is unlimited
+  max_clients = 0
+  # Maximum clients of all theaters across gateway instances. 0 is unlimited
+  global_max_clients = 0
+  # Maximum clients of specific theaters, overrides max_clients
+  max_clients_overrides {
+    "theater-id" = 100
+  }
+  # Password protected theaters, bcrypt hash of the password.
+  # Access mode set by theater owner takes precedence
+  password_hashes {
+    "theater-id" = "password-hash"
+  }
+}
+
+# Rate limit theater events with token buckets that are shared between
+# gateway instances. Each event has limits per user and per theater, rate is
+# tokens per second. Events: play, pause, seek, rate, chat, reaction, vote,
+# queue, invite and media_source
+rate_limit {
+  enabled = true
+  event "play" {
+    rate       = 1
+    burst      = 5
+    room_rate  = 2
+    room_burst = 10
+  }
+  event "chat" {
+    rate       = 2
+    burst      = 10
+    room_rate  = 20
+    room_burst = 50
+  }
+}
+
+# Admin api is served on the admin listener next to metrics, requests should
+# have an "Authorization: Bearer <token>" header. Admin api is disabled when
+# token is empty
+admin {
+  token = "super-secure-admin-token"
+}
+
+# Redis and grpc are checked continuously, new logons are refused while any
+# of them is down and connected clients are kept
+health {
+  # Interval in seconds of checking dependencies
+  check_interval = 5
+  # Seconds to wait for dependencies at startup before giving up
+  startup_timeout = 60
+  # Seconds of reporting not ready before closing hubs on shutdown, so load
+  # balancers stop sending new clients
+  drain_timeout = 10
 }
 
 # Sentry config
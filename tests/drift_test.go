@@ -0,0 +1,31 @@
+package tests
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/castyapp/gateway.server/hub"
+)
+
+func TestDrift(t *testing.T) {
+	now := time.Now()
+
+	paused := &hub.PlayerState{Position: 10, Rate: 1, UpdatedAt: now.Add(-time.Minute)}
+	if drift := hub.Drift(paused, 12, 0, now); drift != 2 {
+		t.Fatalf("expected drift from paused position, got %v", drift)
+	}
+
+	playing := &hub.PlayerState{Playing: true, Position: 10, Rate: 2, UpdatedAt: now.Add(-5 * time.Second)}
+	if drift := hub.Drift(playing, 20, 0, now); math.Abs(drift) > 1e-9 {
+		t.Fatalf("expected no drift of a client in sync, got %v", drift)
+	}
+
+	// a position reported half a round trip ago is compared with the clock then
+	if drift := hub.Drift(playing, 18, 2*time.Second, now); math.Abs(drift) > 1e-9 {
+		t.Fatalf("expected round trip to be compensated, got %v", drift)
+	}
+	if drift := hub.Drift(playing, 17, 0, now); math.Abs(drift+3) > 1e-9 {
+		t.Fatalf("expected client to be 3s behind, got %v", drift)
+	}
+}
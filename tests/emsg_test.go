@@ -0,0 +1,25 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/castyapp/gateway.server/hub"
+	"github.com/castyapp/libcasty-protocol-go/proto"
+)
+
+// Values of gateway EMSGs are part of the protocol of clients
+func TestEMSGValues(t *testing.T) {
+	values := map[proto.EMSG]int32{
+		hub.EMSG_THEATER_SEEK:        1000,
+		hub.EMSG_THEATER_INVITE:      1016,
+		hub.EMSG_THROTTLED:           1024,
+		hub.EMSG_SYSTEM_NOTICE:       1025,
+		hub.EMSG_SERVICE_UNAVAILABLE: 1027,
+		hub.EMSG_THEATER_CREDENTIALS: 1028,
+	}
+	for eMsg, value := range values {
+		if int32(eMsg) != value {
+			t.Fatalf("expected EMSG %d to keep value %d", eMsg, value)
+		}
+	}
+}
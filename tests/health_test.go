@@ -0,0 +1,52 @@
+package tests
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/castyapp/gateway.server/health"
+)
+
+type drainingHub struct {
+	draining bool
+}
+
+func (h *drainingHub) Name() string {
+	return "test"
+}
+
+func (h *drainingHub) Connections() int {
+	return 0
+}
+
+func (h *drainingHub) Draining() bool {
+	return h.draining
+}
+
+func serveHealth(t *testing.T, handler http.HandlerFunc) (int, map[string]interface{}) {
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	body := make(map[string]interface{})
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("could not decode health report: %v", err)
+	}
+	return w.Code, body
+}
+
+func TestLivenessDoesNotReportReadiness(t *testing.T) {
+	hub := &drainingHub{draining: true}
+	status, body := serveHealth(t, health.LivenessHandler(hub))
+	if status != http.StatusOK {
+		t.Fatalf("expected a draining hub to be alive, got %d", status)
+	}
+	if _, ok := body["ready"]; ok {
+		t.Fatalf("expected liveness not to report readiness: %v", body)
+	}
+
+	status, body = serveHealth(t, health.ReadinessHandler(hub))
+	if status != http.StatusServiceUnavailable || body["ready"] != false {
+		t.Fatalf("expected a draining hub not to be ready, got %d: %v", status, body)
+	}
+}
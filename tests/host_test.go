@@ -0,0 +1,103 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/castyapp/gateway.server/hub"
+	"github.com/castyapp/gateway.server/redis"
+)
+
+type hostMembers struct {
+	t    *testing.T
+	host *hub.TheaterHost
+}
+
+func (m *hostMembers) join(userId string, changes bool) {
+	ctx := context.Background()
+	redis.Client.HIncrBy(ctx, redis.Keys.TheaterMembers("theater"), userId, 1)
+	changed, err := m.host.Joined(ctx, userId)
+	if err != nil || changed != changes {
+		m.t.Fatalf("bad host change when %s joined: %v: %v", userId, changed, err)
+	}
+}
+
+func (m *hostMembers) leave(userId string, changes bool) {
+	ctx := context.Background()
+	redis.Client.HDel(ctx, redis.Keys.TheaterMembers("theater"), userId)
+	changed, err := m.host.Left(ctx, userId)
+	if err != nil || changed != changes {
+		m.t.Fatalf("bad host change when %s left: %v: %v", userId, changed, err)
+	}
+}
+
+func (m *hostMembers) expect(userId string) {
+	if host := m.host.Host(context.Background()); host != userId {
+		m.t.Fatalf("bad host: %s, expected %s", host, userId)
+	}
+}
+
+func TestHostHandoff(t *testing.T) {
+	setupRedis(t)
+	members := &hostMembers{t: t, host: hub.NewTheaterHost("theater", "owner")}
+
+	// owner is the host when nobody took over
+	members.expect("owner")
+	members.join("owner", true)
+	members.join("a", false)
+	members.join("b", false)
+	members.expect("owner")
+
+	// control goes to the member who is watching for the longest time
+	members.leave("owner", true)
+	members.expect("a")
+
+	// members that are not the host leave without a handoff
+	members.leave("b", false)
+	members.expect("a")
+
+	// co-host is promoted before older members
+	members.join("b", false)
+	members.join("c", false)
+	if err := members.host.SetCoHost(context.Background(), "c"); err != nil {
+		t.Fatalf("could not set co-host: %v", err)
+	}
+	members.leave("a", true)
+	members.expect("c")
+
+	// nobody left to hand off to, owner gets control back
+	members.leave("b", false)
+	members.leave("c", false)
+	members.expect("owner")
+}
+
+func TestHostRestoredToOwner(t *testing.T) {
+	setupRedis(t)
+	members := &hostMembers{t: t, host: hub.NewTheaterHost("theater", "owner")}
+
+	members.join("owner", true)
+	members.join("a", false)
+	members.leave("owner", true)
+	members.expect("a")
+
+	// owner takes control back when returns
+	members.join("owner", true)
+	members.expect("owner")
+	members.leave("a", false)
+	members.expect("owner")
+}
+
+func TestHostTakenOverByPresentMember(t *testing.T) {
+	setupRedis(t)
+	members := &hostMembers{t: t, host: hub.NewTheaterHost("theater", "owner")}
+
+	// owner is not watching, first member that joins takes control
+	members.join("a", true)
+	members.expect("a")
+	members.join("b", false)
+	members.expect("a")
+
+	if err := members.host.SetCoHost(context.Background(), "unknown"); err == nil {
+		t.Fatalf("expected a co-host that is not a member to be refused")
+	}
+}
@@ -0,0 +1,20 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/castyapp/gateway.server/instance"
+)
+
+func TestClientIdOwner(t *testing.T) {
+	first, second := instance.NextClientId(), instance.NextClientId()
+	if first == second {
+		t.Fatalf("expected unique client ids, got %s twice", first)
+	}
+	if owner := instance.Owner(first); owner != instance.ID {
+		t.Fatalf("bad owner of client %s: %s", first, owner)
+	}
+	if owner := instance.Owner("12345"); owner != "" {
+		t.Fatalf("expected no owner of a legacy client id, got %s", owner)
+	}
+}
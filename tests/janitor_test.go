@@ -0,0 +1,200 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/castyapp/gateway.server/config"
+	"github.com/castyapp/gateway.server/hub"
+	"github.com/castyapp/gateway.server/instance"
+	"github.com/castyapp/gateway.server/redis"
+)
+
+// Record clients of an instance that crashed without cleaning up
+func crashInstance(t *testing.T, id string, clients map[string]string) {
+	ctx := context.Background()
+	redis.Client.SAdd(ctx, redis.Keys.Instances(), id)
+	for clientId, record := range clients {
+		redis.Client.HSet(ctx, redis.Keys.InstanceClients(id), clientId, record)
+	}
+}
+
+func TestSweepReleasesClientsOfDeadInstances(t *testing.T) {
+	setupCapacity(t, 1, 0, nil)
+	ctx := context.Background()
+	users, theaters := hub.NewUserHub(), hub.NewTheaterHub()
+
+	// grpc calls of released users are skipped without a service token
+	token := config.Map.Grpc.ServiceToken
+	config.Map.Grpc.ServiceToken = ""
+	defer func() { config.Map.Grpc.ServiceToken = token }()
+
+	takeSeat(t, "theater", "dead-1", true)
+	waitForSeat(t, "theater", "dead-2", "alive-1")
+	redis.Client.HIncrBy(ctx, redis.Keys.TheaterMembers("theater"), "user", 1)
+	redis.Client.Set(ctx, redis.Keys.InstanceAlive("alive"), "{}", time.Minute)
+	crashInstance(t, "dead", map[string]string{
+		"dead-1": `{"hub":"theater","room":"theater","user_id":"user"}`,
+		"dead-2": `{"hub":"theater","room":"theater","guest":true,"waiting":true}`,
+		"dead-3": `{"hub":"user","room":"guest","guest":true}`,
+	})
+
+	if err := hub.Sweep(ctx, users, theaters); err != nil {
+		t.Fatalf("could not sweep: %v", err)
+	}
+
+	// the freed seat goes to the first waiting client of an alive instance
+	clients := redis.Client.SMembers(ctx, redis.Keys.TheaterClients("theater")).Val()
+	if len(clients) != 1 || clients[0] != "alive-1" {
+		t.Fatalf("bad seated clients: %v", clients)
+	}
+	if waiting := redis.Client.LLen(ctx, redis.Keys.TheaterWaiting("theater")).Val(); waiting != 0 {
+		t.Fatalf("expected nobody to be waiting, got %d", waiting)
+	}
+	if redis.Client.HExists(ctx, redis.Keys.TheaterMembers("theater"), "user").Val() {
+		t.Fatalf("expected member of dead instance to leave theater")
+	}
+	expectSeats(t, "alive", 1)
+
+	// dead instance is forgotten with its seats
+	if redis.Client.HExists(ctx, redis.Keys.TheaterSeats(), "dead").Val() {
+		t.Fatalf("expected seats of dead instance to be dropped")
+	}
+	if redis.Client.SIsMember(ctx, redis.Keys.Instances(), "dead").Val() {
+		t.Fatalf("expected dead instance to be forgotten")
+	}
+	if redis.Client.Exists(ctx, redis.Keys.InstanceClients("dead")).Val() != 0 {
+		t.Fatalf("expected clients of dead instance to be released")
+	}
+}
+
+func TestSweepIsLocked(t *testing.T) {
+	setupRedis(t)
+	ctx := context.Background()
+	crashInstance(t, "dead", map[string]string{
+		"dead-1": `{"hub":"theater","room":"theater","guest":true}`,
+	})
+
+	// janitor of another instance is cleaning up
+	redis.Client.Set(ctx, redis.Keys.JanitorLock(), "other", time.Minute)
+	if err := hub.Sweep(ctx, hub.NewUserHub(), hub.NewTheaterHub()); err != nil {
+		t.Fatalf("could not sweep: %v", err)
+	}
+	if redis.Client.HLen(ctx, redis.Keys.InstanceClients("dead")).Val() != 1 {
+		t.Fatalf("expected clients not to be released while another janitor runs")
+	}
+	if lock := redis.Client.Get(ctx, redis.Keys.JanitorLock()).Val(); lock != "other" {
+		t.Fatalf("expected lock of another janitor to be kept, got %s", lock)
+	}
+}
+
+type restoringHub struct {
+	restored []map[string]string
+	// called while restoring, e.g. to let the janitor lock expire
+	onRestore func()
+}
+
+func (h *restoringHub) Name() string {
+	return "test"
+}
+
+func (h *restoringHub) Connections() int {
+	return 0
+}
+
+func (h *restoringHub) Restore(ctx context.Context, owned map[string]string) {
+	h.restored = append(h.restored, owned)
+	if h.onRestore != nil {
+		h.onRestore()
+	}
+}
+
+func TestRestoreAfterMissedHeartbeats(t *testing.T) {
+	setupRedis(t)
+	ctx := context.Background()
+
+	restoring := new(restoringHub)
+	started, stop := context.WithCancel(ctx)
+	if err := instance.Start(started, restoring); err != nil {
+		t.Fatalf("could not start instance: %v", err)
+	}
+	// heartbeats are sent by test
+	stop()
+
+	instance.Own(ctx, instance.ID+"-released", []byte(`{}`))
+	instance.Own(ctx, instance.ID+"-kept", []byte(`{}`))
+	if err := instance.Heartbeat(ctx); err != nil {
+		t.Fatalf("could not send heartbeat: %v", err)
+	}
+	if len(restoring.restored) != 0 {
+		t.Fatalf("expected nothing to be restored while instance is alive")
+	}
+
+	// instance was taken for dead and janitor released one of its clients
+	redis.Client.Del(ctx, redis.Keys.InstanceAlive(instance.ID))
+	if _, err := instance.Release(ctx, instance.ID, instance.ID+"-released"); err != nil {
+		t.Fatalf("could not release client: %v", err)
+	}
+
+	// clients are not restored while janitor is cleaning up
+	redis.Client.Set(ctx, redis.Keys.JanitorLock(), "other", time.Minute)
+	if err := instance.Heartbeat(ctx); err != nil {
+		t.Fatalf("could not send heartbeat: %v", err)
+	}
+	if len(restoring.restored) != 0 {
+		t.Fatalf("expected nothing to be restored while janitor is locked")
+	}
+	if lock := redis.Client.Get(ctx, redis.Keys.JanitorLock()).Val(); lock != "other" {
+		t.Fatalf("expected lock of janitor to be kept, got %s", lock)
+	}
+
+	// restored on the next heartbeat once janitor is done
+	redis.Client.Del(ctx, redis.Keys.JanitorLock())
+	if err := instance.Heartbeat(ctx); err != nil {
+		t.Fatalf("could not send heartbeat: %v", err)
+	}
+	if len(restoring.restored) != 1 {
+		t.Fatalf("expected clients to be restored once, restored %d times", len(restoring.restored))
+	}
+	owned := restoring.restored[0]
+	if _, ok := owned[instance.ID+"-released"]; ok || len(owned) != 1 {
+		t.Fatalf("bad owned clients to restore: %v", owned)
+	}
+	if redis.Client.Exists(ctx, redis.Keys.JanitorLock()).Val() != 0 {
+		t.Fatalf("expected janitor lock to be released after restoring")
+	}
+
+	if err := instance.Heartbeat(ctx); err != nil {
+		t.Fatalf("could not send heartbeat: %v", err)
+	}
+	if len(restoring.restored) != 1 {
+		t.Fatalf("expected clients not to be restored again")
+	}
+}
+
+func TestRestoreKeepsJanitorLockOfOthers(t *testing.T) {
+	setupRedis(t)
+	ctx := context.Background()
+
+	// lock of this instance expires while restoring and another janitor takes it
+	restoring := &restoringHub{onRestore: func() {
+		redis.Client.Set(ctx, redis.Keys.JanitorLock(), "other", time.Minute)
+	}}
+	started, stop := context.WithCancel(ctx)
+	if err := instance.Start(started, restoring); err != nil {
+		t.Fatalf("could not start instance: %v", err)
+	}
+	stop()
+
+	redis.Client.Del(ctx, redis.Keys.InstanceAlive(instance.ID))
+	if err := instance.Heartbeat(ctx); err != nil {
+		t.Fatalf("could not send heartbeat: %v", err)
+	}
+	if len(restoring.restored) != 1 {
+		t.Fatalf("expected clients to be restored, restored %d times", len(restoring.restored))
+	}
+	if lock := redis.Client.Get(ctx, redis.Keys.JanitorLock()).Val(); lock != "other" {
+		t.Fatalf("expected lock of another janitor to be kept, got %q", lock)
+	}
+}
@@ -0,0 +1,30 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/castyapp/gateway.server/redis"
+)
+
+func TestKeyspacePrefix(t *testing.T) {
+	keys := redis.NewKeyspace("")
+	if key := keys.TheaterClients("theater-id"); key != "theater:clients:{theater-id}" {
+		t.Fatalf("bad unprefixed key: %s", key)
+	}
+	keys = redis.NewKeyspace("staging:")
+	if key := keys.TheaterClients("theater-id"); key != "staging:theater:clients:{theater-id}" {
+		t.Fatalf("bad prefixed key: %s", key)
+	}
+	if channel := keys.UserEvents("user-id"); channel != "staging:user:events:user-id" {
+		t.Fatalf("bad prefixed channel: %s", channel)
+	}
+	if key := keys.TheaterScheduleLeader("theater-id", 1000); key != "staging:theater:schedule:{theater-id}:1000" {
+		t.Fatalf("bad schedule leader key: %s", key)
+	}
+	if key := keys.UserRateLimit("user-id", "chat"); key != "staging:ratelimit:user:user-id:chat" {
+		t.Fatalf("bad rate limit key: %s", key)
+	}
+	if err := redis.ValidatePrefix("tenant{1}"); err == nil {
+		t.Fatalf("expected prefix with hash tag to be invalid")
+	}
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/castyapp/gateway.server/redis"
+)
+
+// Wait until redis has a subscriber of channel, subscribing is asynchronous
+func waitSubscribed(t *testing.T, channel string) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		subscribers, err := redis.Client.PubSubNumSub(context.Background(), channel).Result()
+		if err == nil && subscribers[channel] > 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("channel %s was not subscribed", channel)
+}
+
+func receive(t *testing.T, messages chan string, expected string) {
+	select {
+	case payload := <-messages:
+		if payload != expected {
+			t.Fatalf("expected %q, got %q", expected, payload)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("expected %q to be received", expected)
+	}
+}
+
+func TestSubscriptionFanOut(t *testing.T) {
+	setupRedis(t)
+	ctx := context.Background()
+	channel := redis.Keys.SystemEvents()
+
+	first, second := make(chan string, 1), make(chan string, 1)
+	a, err := redis.Subscribe(channel, func(payload string) { first <- payload })
+	if err != nil {
+		t.Fatalf("could not subscribe: %v", err)
+	}
+	b, err := redis.Subscribe(channel, func(payload string) { second <- payload })
+	if err != nil {
+		t.Fatalf("could not subscribe: %v", err)
+	}
+	waitSubscribed(t, channel)
+
+	// both listeners share one redis subscription
+	if subscribers := redis.Client.PubSubNumSub(ctx, channel).Val()[channel]; subscribers != 1 {
+		t.Fatalf("expected one redis subscriber, got %d", subscribers)
+	}
+
+	redis.Client.Publish(ctx, channel, "hello")
+	receive(t, first, "hello")
+	receive(t, second, "hello")
+
+	// channel stays subscribed until its last listener is gone
+	a.Unsubscribe()
+	redis.Client.Publish(ctx, channel, "again")
+	receive(t, second, "again")
+	select {
+	case payload := <-first:
+		t.Fatalf("unsubscribed listener received %q", payload)
+	default:
+	}
+
+	b.Unsubscribe()
+	deadline := time.Now().Add(time.Second)
+	for redis.Client.PubSubNumSub(ctx, channel).Val()[channel] > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected channel to be unsubscribed with its last listener")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,112 @@
+package tests
+
+import (
+	"context"
+	"testing"
+
+	"github.com/castyapp/gateway.server/hub"
+	"github.com/castyapp/gateway.server/redis"
+	"github.com/castyapp/libcasty-protocol-go/proto"
+)
+
+func queueIds(t *testing.T, queue *hub.Queue) []string {
+	items, err := queue.Items(context.Background())
+	if err != nil {
+		t.Fatalf("could not get queue items: %v", err)
+	}
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.Id)
+	}
+	return ids
+}
+
+func expectQueue(t *testing.T, queue *hub.Queue, expected ...string) {
+	ids := queueIds(t, queue)
+	if len(ids) != len(expected) {
+		t.Fatalf("bad queue: %v, expected %v", ids, expected)
+	}
+	for i := range ids {
+		if ids[i] != expected[i] {
+			t.Fatalf("bad queue: %v, expected %v", ids, expected)
+		}
+	}
+}
+
+func TestQueueAddMoveRemove(t *testing.T) {
+	setupRedis(t)
+	ctx := context.Background()
+	queue := hub.NewQueue("theater")
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := queue.Add(ctx, &proto.MediaSource{Id: id, Title: id}); err != nil {
+			t.Fatalf("could not add %s to queue: %v", id, err)
+		}
+	}
+	if err := queue.Add(ctx, &proto.MediaSource{Id: "b"}); err == nil {
+		t.Fatalf("expected a queued media source not to be added again")
+	}
+	expectQueue(t, queue, "a", "b", "c")
+
+	if err := queue.Move(ctx, "c", 0); err != nil {
+		t.Fatalf("could not move c: %v", err)
+	}
+	expectQueue(t, queue, "c", "a", "b")
+	if err := queue.Move(ctx, "c", 10); err != nil {
+		t.Fatalf("could not move c: %v", err)
+	}
+	expectQueue(t, queue, "a", "b", "c")
+	if err := queue.Move(ctx, "unknown", 0); err == nil {
+		t.Fatalf("expected moving a media source that's not queued to fail")
+	}
+
+	if err := queue.Remove(ctx, "b"); err != nil {
+		t.Fatalf("could not remove b: %v", err)
+	}
+	expectQueue(t, queue, "a", "c")
+	if err := queue.Add(ctx, &proto.MediaSource{Id: "b"}); err != nil {
+		t.Fatalf("expected a removed media source to be added again: %v", err)
+	}
+	expectQueue(t, queue, "a", "c", "b")
+}
+
+func TestQueueSkip(t *testing.T) {
+	setupRedis(t)
+	ctx := context.Background()
+	queue := hub.NewQueue("theater")
+
+	for _, id := range []string{"a", "b", "c"} {
+		if err := queue.Add(ctx, &proto.MediaSource{Id: id}); err != nil {
+			t.Fatalf("could not add %s to queue: %v", id, err)
+		}
+	}
+	// an id left without its media source is skipped
+	redis.Client.HDel(ctx, redis.Keys.TheaterQueueItems("theater"), "b")
+
+	next, err := queue.Next(ctx)
+	if err != nil || next == nil || next.Id != "a" {
+		t.Fatalf("expected a to be next, got %v: %v", next, err)
+	}
+	if current, _ := queue.Current(ctx); current == nil || current.Id != "a" {
+		t.Fatalf("expected a to be current, got %v", current)
+	}
+	expectQueue(t, queue, "c")
+
+	if next, err = queue.Next(ctx); err != nil || next == nil || next.Id != "c" {
+		t.Fatalf("expected c to be next, got %v: %v", next, err)
+	}
+	if next, err = queue.Next(ctx); err != nil || next != nil {
+		t.Fatalf("expected an empty queue, got %v: %v", next, err)
+	}
+	// the last item keeps playing when queue runs out
+	if current, _ := queue.Current(ctx); current == nil || current.Id != "c" {
+		t.Fatalf("expected c to be current, got %v", current)
+	}
+
+	if err := queue.ClearCurrent(ctx); err != nil {
+		t.Fatalf("could not clear current: %v", err)
+	}
+	if current, err := queue.Current(ctx); err != nil || current != nil {
+		t.Fatalf("expected no current media source, got %v: %v", current, err)
+	}
+}
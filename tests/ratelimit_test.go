@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/castyapp/gateway.server/ratelimit"
+	"github.com/castyapp/gateway.server/redis"
+)
+
+func TestTokenBucketUnlimited(t *testing.T) {
+	allowed, retry, err := ratelimit.Allow(context.Background(), redis.Keys.ClientRateLimit("client", "unlimited"), ratelimit.Limit{})
+	if err != nil || !allowed || retry != 0 {
+		t.Fatalf("expected a bucket without limit to allow, got %v %v %v", allowed, retry, err)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	setupRedis(t)
+	ctx := context.Background()
+	limit := ratelimit.Limit{Rate: 1, Burst: 2}
+	bucket := redis.Keys.ClientRateLimit("client", "bucket")
+
+	for i := 0; i < limit.Burst; i++ {
+		allowed, _, err := ratelimit.Allow(ctx, bucket, limit)
+		if err != nil {
+			t.Fatalf("could not take token: %v", err)
+		}
+		if !allowed {
+			t.Fatalf("expected token %d of burst to be allowed", i+1)
+		}
+	}
+
+	allowed, retry, err := ratelimit.Allow(ctx, bucket, limit)
+	if err != nil {
+		t.Fatalf("could not take token: %v", err)
+	}
+	if allowed {
+		t.Fatalf("expected empty bucket to deny")
+	}
+	if retry <= 0 || retry > time.Second {
+		t.Fatalf("bad retry after: %v", retry)
+	}
+}
+
+func TestTokenBucketRefund(t *testing.T) {
+	setupRedis(t)
+	ctx := context.Background()
+	limit := ratelimit.Limit{Rate: 0.01, Burst: 1}
+	bucket := redis.Keys.ClientRateLimit("client", "bucket")
+
+	if allowed, _, err := ratelimit.Allow(ctx, bucket, limit); err != nil || !allowed {
+		t.Fatalf("expected first token to be allowed: %v", err)
+	}
+	if err := ratelimit.Refund(ctx, bucket, limit); err != nil {
+		t.Fatalf("could not refund token: %v", err)
+	}
+	if allowed, _, err := ratelimit.Allow(ctx, bucket, limit); err != nil || !allowed {
+		t.Fatalf("expected refunded token to be allowed: %v", err)
+	}
+
+	// a refund never fills bucket over its burst
+	if err := ratelimit.Refund(ctx, bucket, limit); err != nil {
+		t.Fatalf("could not refund token: %v", err)
+	}
+	if err := ratelimit.Refund(ctx, bucket, limit); err != nil {
+		t.Fatalf("could not refund token: %v", err)
+	}
+	if allowed, _, _ := ratelimit.Allow(ctx, bucket, limit); !allowed {
+		t.Fatalf("expected refunded token to be allowed")
+	}
+	if allowed, _, _ := ratelimit.Allow(ctx, bucket, limit); allowed {
+		t.Fatalf("expected bucket to hold no more than its burst")
+	}
+}
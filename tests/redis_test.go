@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/castyapp/gateway.server/config"
+	"github.com/castyapp/gateway.server/redis"
+)
+
+// Configure redis of REDIS_ADDR or a local redis under a prefix of its own,
+// tests that need redis are skipped when a local redis is not reachable and
+// fail when REDIS_ADDR is not reachable
+func setupRedis(t *testing.T) {
+	addr, required := os.LookupEnv("REDIS_ADDR")
+	if addr == "" {
+		addr = "127.0.0.1:6379"
+	}
+
+	previous, keys := config.Map.Redis, redis.Keys
+	restore := func() {
+		config.Map.Redis = previous
+		redis.Keys = keys
+	}
+
+	config.Map.Redis = config.RedisConfig{
+		Mode:   redis.ModeStandalone,
+		Addr:   addr,
+		Prefix: fmt.Sprintf("test:%d", time.Now().UnixNano()),
+	}
+	if err := redis.Configure(); err != nil {
+		restore()
+		t.Fatalf("could not configure redis: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := redis.Ping(ctx); err != nil {
+		_ = redis.Close()
+		restore()
+		if required {
+			t.Fatalf("redis is not available on %s: %v", addr, err)
+		}
+		t.Skipf("redis is not available on %s: %v", addr, err)
+	}
+
+	t.Cleanup(func() {
+		ctx := context.Background()
+		iter := redis.Client.Scan(ctx, 0, redis.Keys.Prefix()+":*", 100).Iterator()
+		for iter.Next(ctx) {
+			redis.Client.Del(ctx, iter.Val())
+		}
+		_ = redis.Close()
+		restore()
+	})
+}
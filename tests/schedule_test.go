@@ -0,0 +1,57 @@
+package tests
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/castyapp/gateway.server/hub"
+	"github.com/castyapp/gateway.server/redis"
+)
+
+func TestClaimSchedule(t *testing.T) {
+	setupRedis(t)
+	ctx := context.Background()
+	start := time.Now().Add(-time.Second)
+	scheduleKey := redis.Keys.TheaterSchedule("theater")
+	redis.Client.Set(ctx, scheduleKey, start.UnixNano()/int64(time.Millisecond), time.Hour)
+
+	// every instance notices the schedule, one of them starts it
+	var claims int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			claimed, err := hub.ClaimSchedule(ctx, "theater", start)
+			if err != nil {
+				t.Errorf("could not claim schedule: %v", err)
+			}
+			if claimed {
+				atomic.AddInt32(&claims, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if claims != 1 {
+		t.Fatalf("expected schedule to be claimed once, got %d claims", claims)
+	}
+	if redis.Client.Exists(ctx, scheduleKey).Val() != 0 {
+		t.Fatalf("expected claimed schedule to be removed")
+	}
+
+	// an instance that is late to notice does not start it again
+	if claimed, err := hub.ClaimSchedule(ctx, "theater", start); err != nil || claimed {
+		t.Fatalf("expected a started schedule not to be claimed again: %v", err)
+	}
+
+	// a new schedule of theater has a leader of its own
+	if claimed, err := hub.ClaimSchedule(ctx, "theater", start.Add(time.Minute)); err != nil || !claimed {
+		t.Fatalf("expected a new schedule to be claimed: %v", err)
+	}
+	if claimed, err := hub.ClaimSchedule(ctx, "other", start); err != nil || !claimed {
+		t.Fatalf("expected schedule of another theater to be claimed: %v", err)
+	}
+}
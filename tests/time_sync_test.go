@@ -0,0 +1,20 @@
+package tests
+
+import (
+	"testing"
+	"time"
+
+	"github.com/castyapp/gateway.server/hub"
+)
+
+func TestSmoothRTT(t *testing.T) {
+	if rtt := hub.SmoothRTT(0, 100*time.Millisecond); rtt != 100*time.Millisecond {
+		t.Fatalf("expected first sample to be the estimate, got %v", rtt)
+	}
+	if rtt := hub.SmoothRTT(100*time.Millisecond, 200*time.Millisecond); rtt != 120*time.Millisecond {
+		t.Fatalf("expected sample to be smoothed into estimate, got %v", rtt)
+	}
+	if rtt := hub.SmoothRTT(100*time.Millisecond, -time.Second); rtt != 100*time.Millisecond {
+		t.Fatalf("expected negative sample to be ignored, got %v", rtt)
+	}
+}
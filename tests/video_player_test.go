@@ -0,0 +1,22 @@
+package tests
+
+import (
+	"math"
+	"testing"
+
+	"github.com/castyapp/gateway.server/hub"
+)
+
+func TestValidatePlaybackRate(t *testing.T) {
+	for _, rate := range []float32{0.25, 1, 1.5, 4} {
+		if err := hub.ValidatePlaybackRate(rate); err != nil {
+			t.Fatalf("expected rate %v to be valid: %v", rate, err)
+		}
+	}
+	invalid := []float32{0, -1, 0.1, 4.5, float32(math.NaN()), float32(math.Inf(1))}
+	for _, rate := range invalid {
+		if err := hub.ValidatePlaybackRate(rate); err == nil {
+			t.Fatalf("expected rate %v to be invalid", rate)
+		}
+	}
+}
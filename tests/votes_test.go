@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/castyapp/gateway.server/config"
+	"github.com/castyapp/gateway.server/hub"
+	"github.com/castyapp/gateway.server/redis"
+)
+
+func TestRequiredVotes(t *testing.T) {
+	previous := config.Map.Theater.VoteThreshold
+	defer func() { config.Map.Theater.VoteThreshold = previous }()
+
+	tests := []struct {
+		threshold float64
+		members   int64
+		required  int64
+	}{
+		{0, 3, 2},
+		{0.5, 0, 1},
+		{0.5, 1, 1},
+		{0.5, 4, 2},
+		{0.5, 5, 3},
+		{1, 4, 4},
+		// invalid thresholds fall back to the default
+		{1.5, 4, 2},
+	}
+	for _, test := range tests {
+		config.Map.Theater.VoteThreshold = test.threshold
+		if required := hub.RequiredVotes(test.members); required != test.required {
+			t.Fatalf("bad required votes of %d members with threshold %v: %d, expected %d",
+				test.members, test.threshold, required, test.required)
+		}
+	}
+}
+
+func TestCastVote(t *testing.T) {
+	setupRedis(t)
+	ctx := context.Background()
+
+	first, err := hub.CastVote(ctx, "theater", hub.VotePause, "a", time.Minute)
+	if err != nil {
+		t.Fatalf("could not cast vote: %v", err)
+	}
+	if !first.Started || first.Id == "" || first.Votes != 1 {
+		t.Fatalf("expected first vote to start a vote, got %+v", first)
+	}
+
+	// a member is counted once
+	again, err := hub.CastVote(ctx, "theater", hub.VotePause, "a", time.Minute)
+	if err != nil || again.Started || again.Id != first.Id || again.Votes != 1 {
+		t.Fatalf("expected vote of a to be counted once, got %+v: %v", again, err)
+	}
+
+	second, err := hub.CastVote(ctx, "theater", hub.VotePause, "b", time.Minute)
+	if err != nil || second.Started || second.Id != first.Id || second.Votes != 2 {
+		t.Fatalf("expected vote of b to join the vote, got %+v: %v", second, err)
+	}
+
+	// votes of other actions are separate
+	other, err := hub.CastVote(ctx, "theater", hub.VoteSkip, "a", time.Minute)
+	if err != nil || !other.Started || other.Votes != 1 {
+		t.Fatalf("expected a separate skip vote, got %+v: %v", other, err)
+	}
+}
+
+func TestVoteExpiry(t *testing.T) {
+	setupRedis(t)
+	ctx := context.Background()
+	key := redis.Keys.TheaterVote("theater", hub.VoteResume)
+
+	ballot, err := hub.CastVote(ctx, "theater", hub.VoteResume, "a", 10*time.Second)
+	if err != nil {
+		t.Fatalf("could not cast vote: %v", err)
+	}
+	ttl := redis.Client.PTTL(ctx, key).Val()
+	if ttl <= 0 || ttl > 10*time.Second {
+		t.Fatalf("bad ttl of vote: %v", ttl)
+	}
+
+	// later votes do not extend the vote
+	if _, err := hub.CastVote(ctx, "theater", hub.VoteResume, "b", time.Hour); err != nil {
+		t.Fatalf("could not cast vote: %v", err)
+	}
+	if ttl := redis.Client.PTTL(ctx, key).Val(); ttl > 10*time.Second {
+		t.Fatalf("expected ttl of vote not to be extended, got %v", ttl)
+	}
+
+	// a vote ends once, either when it passes or when it times out
+	if ended, err := hub.EndVote(ctx, "theater", hub.VoteResume, "other"); err != nil || ended {
+		t.Fatalf("expected another vote not to be ended: %v", err)
+	}
+	if ended, err := hub.EndVote(ctx, "theater", hub.VoteResume, ballot.Id); err != nil || !ended {
+		t.Fatalf("expected vote to be ended: %v", err)
+	}
+	if ended, err := hub.EndVote(ctx, "theater", hub.VoteResume, ballot.Id); err != nil || ended {
+		t.Fatalf("expected an ended vote not to end again: %v", err)
+	}
+
+	next, err := hub.CastVote(ctx, "theater", hub.VoteResume, "b", time.Minute)
+	if err != nil || !next.Started || next.Id == ballot.Id || next.Votes != 1 {
+		t.Fatalf("expected a new vote after the vote ended, got %+v: %v", next, err)
+	}
+}